@@ -0,0 +1,342 @@
+package ucon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// File is an uploaded file of multipart/form-data.
+// Fields of *File or []*File in argument structs are filled by RequestObjectMapper, fields of *multipart.FileHeader are the same.
+type File = multipart.FileHeader
+
+var fileType = reflect.TypeOf((*File)(nil))
+var filesType = reflect.TypeOf([]*File(nil))
+
+// DefaultMaxMemory is the default of RequestObjectMapperOption.MaxMemory.
+const DefaultMaxMemory = 32 << 20 // 32 MB
+
+// ErrRequestBodyTooLarge is the error that the request body exceeds RequestObjectMapperOption.MaxBodySize.
+var ErrRequestBodyTooLarge = newHTTPError(http.StatusRequestEntityTooLarge)
+
+// RequestObjectMapperOption is options for RequestObjectMapperWithOption.
+type RequestObjectMapperOption struct {
+	// MaxMemory is the max bytes of multipart/form-data stored in memory, the rest is stored in temporary files.
+	// DefaultMaxMemory is used if it is zero.
+	MaxMemory int64
+	// MaxBodySize is the max bytes of the request body. There is no limit if it is zero.
+	// ErrRequestBodyTooLarge is returned if the body exceeds it.
+	MaxBodySize int64
+}
+
+// InPath is a marker to embed into an argument struct, the struct is filled only from path parameters.
+// Argument structs without markers are filled by RequestObjectMapper as before, and only the first one is filled.
+type InPath struct{}
+
+// InQuery is a marker to embed into an argument struct, the struct is filled only from the query string.
+type InQuery struct{}
+
+// InHeader is a marker to embed into an argument struct, the struct is filled only from request headers.
+// Fields are matched to canonical header keys, e.g. `json:"X-Request-Id"`.
+type InHeader struct{}
+
+// InCookie is a marker to embed into an argument struct, the struct is filled only from cookies.
+type InCookie struct{}
+
+// InBody is a marker to embed into an argument struct, the struct is filled only from the request body.
+type InBody struct{}
+
+// bindingSource is a part of the request which an argument struct is filled from.
+type bindingSource int
+
+const (
+	// sourceAll is the default of RequestObjectMapper. path parameters, the query string and the body are merged.
+	sourceAll bindingSource = iota
+	sourcePath
+	sourceQuery
+	sourceHeader
+	sourceCookie
+	sourceBody
+)
+
+var markerSources = map[reflect.Type]bindingSource{
+	reflect.TypeOf(InPath{}):   sourcePath,
+	reflect.TypeOf(InQuery{}):  sourceQuery,
+	reflect.TypeOf(InHeader{}): sourceHeader,
+	reflect.TypeOf(InCookie{}): sourceCookie,
+	reflect.TypeOf(InBody{}):   sourceBody,
+}
+
+// bindingSourceOf returns the source given by the marker embedded into the struct.
+func bindingSourceOf(structT reflect.Type) bindingSource {
+	for i, numField := 0, structT.NumField(); i < numField; i++ {
+		sf := structT.Field(i)
+		if !sf.Anonymous {
+			continue
+		}
+		if source, ok := markerSources[sf.Type]; ok {
+			return source
+		}
+	}
+
+	return sourceAll
+}
+
+// fieldSourceOf returns the source and the name given by `swagger:"name,in=header"` or `swagger:"name,in=cookie"`.
+// Other sources are not specific to the field, it returns sourceAll for them.
+func fieldSourceOf(sf reflect.StructField) (bindingSource, string) {
+	texts := strings.Split(sf.Tag.Get("swagger"), ",")
+	for _, text := range texts[1:] {
+		switch text {
+		case "in=header":
+			return sourceHeader, texts[0]
+		case "in=cookie":
+			return sourceCookie, texts[0]
+		}
+	}
+
+	return sourceAll, ""
+}
+
+// bindObject makes the idx-th argument struct from the source of the request.
+// If checkPath is true, all path parameters must be found in the struct.
+func (b *Bubble) bindObject(idx int, source bindingSource, checkPath bool, opts *RequestObjectMapperOption) (reflect.Value, error) {
+	plan := b.handlerPlan()
+	reqV := reflect.New(b.ArgumentTypes[idx].Elem())
+
+	mapString := valueStringMapper
+	mapStrings := valueStringSliceMapper
+	sb := plan.binding(idx)
+	if sb != nil {
+		mapString = sb.setString
+		mapStrings = sb.setStrings
+	} else if source == sourceHeader || source == sourceCookie {
+		return reflect.Value{}, fmt.Errorf("unsupported struct to bind headers or cookies: %s", reqV.Type().String())
+	}
+
+	// NOTE value will be overwritten by below process
+	// url path extract
+	if source == sourceAll || source == sourcePath {
+		if v := b.Context.Value(PathParameterKey); v != nil {
+			params, ok := v.(map[string]string)
+			if !ok {
+				return reflect.Value{}, ErrInvalidPathParameterType
+			}
+			for key, value := range params {
+				found, _ := mapString(reqV, key, value)
+				if !found && checkPath {
+					return reflect.Value{}, ErrPathParameterFieldMissing
+				}
+			}
+		}
+	}
+
+	// url get parameter
+	if source == sourceAll || source == sourceQuery {
+		for key, ss := range b.R.URL.Query() {
+			_, err := mapStrings(reqV, key, ss)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+		}
+	}
+
+	// request body
+	if source == sourceAll || source == sourceBody {
+		// where is the spec???
+		contentType := b.R.Header.Get("Content-Type")
+		ct := strings.Split(contentType, ";")
+		if ct[0] == "application/x-www-form-urlencoded" {
+			err := b.R.ParseForm()
+			if err != nil {
+				if errors.Is(err, ErrRequestBodyTooLarge) {
+					return reflect.Value{}, ErrRequestBodyTooLarge
+				}
+				return reflect.Value{}, err
+			}
+
+			form := b.R.Form
+			if source == sourceBody {
+				form = b.R.PostForm
+			}
+			for key, ss := range form {
+				_, err := mapStrings(reqV, key, ss)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+			}
+
+		} else if ct[0] == "multipart/form-data" {
+			err := b.parseMultipartForm(opts.MaxMemory)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			form := b.R.Form
+			if source == sourceBody {
+				form = b.R.MultipartForm.Value
+			}
+			for key, ss := range form {
+				_, err := mapStrings(reqV, key, ss)
+				if err != nil {
+					return reflect.Value{}, err
+				}
+			}
+			if sb != nil {
+				for key, fb := range sb.files {
+					fb.setFiles(reqV, b.R.MultipartForm.File[key])
+				}
+			}
+
+		} else if mediaType, codec := b.mux.decoder(contentType); codec != nil {
+			body, err := b.requestBody()
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			if len(body) == 2 && mediaType == "application/json" {
+				// dirty hack. {} map to []interface or [] map to normal struct.
+			} else if len(body) != 0 {
+				err := codec.Unmarshal(body, reqV.Interface())
+				if err != nil {
+					return reflect.Value{}, newBadRequestf(err.Error())
+				}
+			}
+
+		} else if ct[0] != "" && b.hasBody() {
+			return reflect.Value{}, ErrUnsupportedMediaType
+		}
+	}
+
+	// request headers and cookies
+	if source == sourceHeader {
+		err := bindHeaders(reqV, b.R, sb.fields)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	} else if source == sourceCookie {
+		err := bindCookies(reqV, b.R, sb.fields)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	if sb != nil {
+		// fields tagged as `swagger:",in=header"` or `swagger:",in=cookie"`, the body must not spoof those.
+		for _, fb := range sb.headers {
+			fb.zero(reqV)
+		}
+		for _, fb := range sb.cookies {
+			fb.zero(reqV)
+		}
+		err := bindHeaders(reqV, b.R, sb.headers)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		err = bindCookies(reqV, b.R, sb.cookies)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	return reqV, nil
+}
+
+func bindHeaders(target reflect.Value, r *http.Request, fields map[string]*fieldBinding) error {
+	for key, fb := range fields {
+		ss := r.Header[http.CanonicalHeaderKey(key)]
+		if len(ss) == 0 {
+			continue
+		}
+		err := fb.setStrings(target, ss)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func bindCookies(target reflect.Value, r *http.Request, fields map[string]*fieldBinding) error {
+	for key, fb := range fields {
+		c, err := r.Cookie(key)
+		if err != nil {
+			continue
+		}
+		err = fb.setString(target, c.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Bubble) parseMultipartForm(maxMemory int64) error {
+	if b.R.MultipartForm != nil {
+		return nil
+	}
+
+	err := b.R.ParseMultipartForm(maxMemory)
+	if errors.Is(err, ErrRequestBodyTooLarge) {
+		return ErrRequestBodyTooLarge
+	} else if err != nil {
+		return newBadRequestf(err.Error())
+	}
+
+	return nil
+}
+
+// limitedBody is like http.MaxBytesReader, but returns ErrRequestBodyTooLarge.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.remaining < 0 {
+		return 0, ErrRequestBodyTooLarge
+	}
+	if int64(len(p)) > lb.remaining+1 {
+		// read 1 more byte to know whether the body exceeds the limit
+		p = p[:lb.remaining+1]
+	}
+
+	n, err := lb.ReadCloser.Read(p)
+	if int64(n) > lb.remaining {
+		n = int(lb.remaining)
+		lb.remaining = -1
+		return n, ErrRequestBodyTooLarge
+	}
+	lb.remaining -= int64(n)
+
+	return n, err
+}
+
+func (b *Bubble) hasBody() bool {
+	return b.R.Body != nil && b.R.Body != http.NoBody && b.R.ContentLength != 0
+}
+
+// requestBody reads the request body once, and returns the same bytes for following calls.
+func (b *Bubble) requestBody() ([]byte, error) {
+	if b.body != nil || b.R.Body == nil {
+		// R.Body is nil in unit test
+		return b.body, nil
+	}
+
+	defer b.R.Body.Close()
+	body, err := ioutil.ReadAll(b.R.Body)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	b.body = body
+
+	return body, nil
+}
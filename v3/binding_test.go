@@ -0,0 +1,377 @@
+package ucon
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type PathOfBinding struct {
+	InPath
+	ID int `json:"id"`
+}
+
+type QueryOfBinding struct {
+	InQuery
+	Offset int      `json:"offset"`
+	Tags   []string `json:"tags"`
+}
+
+type HeaderOfBinding struct {
+	InHeader
+	RequestID string `json:"X-Request-Id"`
+}
+
+type CookieOfBinding struct {
+	InCookie
+	Session string `json:"session"`
+}
+
+type BodyOfBinding struct {
+	InBody
+	Text string `json:"text"`
+}
+
+func TestBindingSourceOf(t *testing.T) {
+	h := func(*PathOfBinding, *QueryOfBinding, *HeaderOfBinding, *CookieOfBinding, *BodyOfBinding, *TargetOfRequestObjectMapper) {
+	}
+	if v := getHandlerPlan(reflect.TypeOf(h)).sources; len(v) != 6 {
+		t.Fatalf("unexpected: %v", v)
+	} else if v[0] != sourcePath || v[1] != sourceQuery || v[2] != sourceHeader || v[3] != sourceCookie || v[4] != sourceBody || v[5] != sourceAll {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestRequestObjectMapper_withSources(t *testing.T) {
+	called := false
+	b, _ := MakeMiddlewareTestBed(t, RequestObjectMapper(), func(path *PathOfBinding, query *QueryOfBinding, header *HeaderOfBinding, cookie *CookieOfBinding, body *BodyOfBinding) {
+		called = true
+		if path.ID != 5 {
+			t.Errorf("unexpected: %v", path.ID)
+		}
+		if query.Offset != 10 {
+			t.Errorf("unexpected: %v", query.Offset)
+		}
+		if v := strings.Join(query.Tags, ","); v != "a,b" {
+			t.Errorf("unexpected: %v", v)
+		}
+		if header.RequestID != "req-1" {
+			t.Errorf("unexpected: %v", header.RequestID)
+		}
+		if cookie.Session != "s3cr3t" {
+			t.Errorf("unexpected: %v", cookie.Session)
+		}
+		if body.Text != "Hi!" {
+			t.Errorf("unexpected: %v", body.Text)
+		}
+	}, &BubbleTestOption{
+		Method: "POST",
+		URL:    "/api/todo/{id}?offset=10&tags=a&tags=b&text=query",
+		Body:   strings.NewReader(`{"text":"Hi!"}`),
+	})
+	b.R.Header.Set("X-Request-ID", "req-1")
+	b.R.AddCookie(&http.Cookie{Name: "session", Value: "s3cr3t"})
+	b.Context = context.WithValue(b.Context, PathParameterKey, map[string]string{
+		"id": "5",
+	})
+	err := b.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Errorf("unexpected: %v", called)
+	}
+}
+
+func TestRequestObjectMapper_withSourceAndRequestObject(t *testing.T) {
+	// the request object does not have the path parameter, it is held by PathOfBinding.
+	b, _ := MakeMiddlewareTestBed(t, RequestObjectMapper(), func(req *BodyOfRequestObject, path *PathOfBinding, body *BodyOfBinding) {
+		if path.ID != 5 {
+			t.Errorf("unexpected: %v", path.ID)
+		}
+		if req.Text != "Hi!" {
+			t.Errorf("unexpected: %v", req.Text)
+		}
+		if body.Text != "Hi!" {
+			t.Errorf("unexpected: %v", body.Text)
+		}
+	}, &BubbleTestOption{
+		Method: "POST",
+		URL:    "/api/todo/{id}",
+		Body:   strings.NewReader(`{"text":"Hi!"}`),
+	})
+	b.Context = context.WithValue(b.Context, PathParameterKey, map[string]string{
+		"id": "5",
+	})
+	err := b.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type BodyOfRequestObject struct {
+	Text string `json:"text"`
+}
+
+func TestRequestObjectMapper_withSourcePathMissing(t *testing.T) {
+	b, _ := MakeMiddlewareTestBed(t, RequestObjectMapper(), func(path *PathOfBinding) {
+		t.Errorf("unexpected call")
+	}, &BubbleTestOption{
+		Method: "GET",
+		URL:    "/api/todo/{name}",
+	})
+	b.Context = context.WithValue(b.Context, PathParameterKey, map[string]string{
+		"name": "foo",
+	})
+	err := b.Next()
+	if err != ErrPathParameterFieldMissing {
+		t.Errorf("unexpected: %v", err)
+	}
+}
+
+func TestServeMux_withSources(t *testing.T) {
+	mux := NewServeMux()
+	mux.Middleware(HTTPRWDI())
+	mux.Middleware(ContextDI())
+	mux.Middleware(RequestObjectMapper())
+	mux.Middleware(ResponseMapper())
+	mux.HandleFunc("PUT", "/api/todo/{id}", func(path *PathOfBinding, header *HeaderOfBinding, body *BodyOfBinding) (map[string]interface{}, error) {
+		return map[string]interface{}{"id": path.ID, "requestID": header.RequestID, "text": body.Text}, nil
+	})
+	mux.Prepare()
+
+	r := httptest.NewRequest("PUT", "/api/todo/7", strings.NewReader("text=Hi%21"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Request-Id", "req-2")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := strings.TrimSpace(w.Body.String()); v != `{"id":7,"requestID":"req-2","text":"Hi!"}` {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+type TargetOfHeaderField struct {
+	ID        int      `json:"id"`
+	RequestID string   `json:"requestID" swagger:"X-Request-Id,in=header"`
+	Tags      []string `json:"tags" swagger:",in=header"`
+	Session   string   `json:"session" swagger:",in=cookie"`
+	Version   int      `json:"version" swagger:",in=cookie"`
+}
+
+func TestRequestObjectMapper_withHeaderAndCookieFields(t *testing.T) {
+	b, _ := MakeMiddlewareTestBed(t, RequestObjectMapper(), func(req *TargetOfHeaderField) {
+		if req.ID != 5 {
+			t.Errorf("unexpected: %v", req.ID)
+		}
+		if req.RequestID != "req-1" {
+			t.Errorf("unexpected: %v", req.RequestID)
+		}
+		if v := strings.Join(req.Tags, ","); v != "a,b" {
+			t.Errorf("unexpected: %v", v)
+		}
+		if req.Session != "s3cr3t" {
+			t.Errorf("unexpected: %v", req.Session)
+		}
+		if req.Version != 3 {
+			t.Errorf("unexpected: %v", req.Version)
+		}
+	}, &BubbleTestOption{
+		Method: "GET",
+		// query parameters never fill fields tagged as header or cookie.
+		URL: "/api/todo/{id}?requestID=query&session=query",
+	})
+	b.R.Header.Set("X-Request-Id", "req-1")
+	b.R.Header.Add("Tags", "a")
+	b.R.Header.Add("Tags", "b")
+	b.R.AddCookie(&http.Cookie{Name: "session", Value: "s3cr3t"})
+	b.R.AddCookie(&http.Cookie{Name: "version", Value: "3"})
+	b.Context = context.WithValue(b.Context, PathParameterKey, map[string]string{
+		"id": "5",
+	})
+	err := b.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type EmbeddedOfHeaderField struct {
+	Token string `json:"token" swagger:",in=header"`
+}
+
+type TargetOfHeaderFieldWithEmbeddedPtr struct {
+	*EmbeddedOfHeaderField
+	Text string `json:"text"`
+}
+
+func TestRequestObjectMapper_withSpoofedHeaderAndCookieFields(t *testing.T) {
+	called := false
+	b, _ := MakeMiddlewareTestBed(t, RequestObjectMapper(), func(req *TargetOfHeaderField) {
+		called = true
+		if req.ID != 5 {
+			t.Errorf("unexpected: %v", req.ID)
+		}
+		// the body never fills fields tagged as header or cookie
+		if req.RequestID != "" || len(req.Tags) != 0 || req.Session != "" {
+			t.Errorf("unexpected: %#v", req)
+		}
+		if req.Version != 3 {
+			t.Errorf("unexpected: %v", req.Version)
+		}
+	}, &BubbleTestOption{
+		Method: "POST",
+		URL:    "/api/todo",
+		Body:   strings.NewReader(`{"id":5,"requestID":"spoofed","tags":["spoofed"],"session":"spoofed","version":100}`),
+	})
+	b.R.AddCookie(&http.Cookie{Name: "version", Value: "3"})
+	err := b.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Errorf("unexpected: %v", called)
+	}
+
+	called = false
+	b, _ = MakeMiddlewareTestBed(t, RequestObjectMapper(), func(req *TargetOfHeaderFieldWithEmbeddedPtr) {
+		called = true
+		if req.Text != "hi" {
+			t.Errorf("unexpected: %v", req.Text)
+		}
+		// the nil embedded pointer is allocated to bind the header
+		if req.EmbeddedOfHeaderField == nil || req.Token != "t0ken" {
+			t.Errorf("unexpected: %#v", req.EmbeddedOfHeaderField)
+		}
+	}, &BubbleTestOption{
+		Method: "POST",
+		URL:    "/api/todo",
+		Body:   strings.NewReader(`{"text":"hi","token":"spoofed"}`),
+	})
+	b.R.Header.Set("Token", "t0ken")
+	err = b.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Errorf("unexpected: %v", called)
+	}
+}
+
+type TargetOfMultipart struct {
+	ID          int     `json:"id"`
+	Text        string  `json:"text"`
+	Attachment  *File   `json:"attachment"`
+	Attachments []*File `json:"attachments"`
+}
+
+func newMultipartRequest(t *testing.T, url string) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	err := mw.WriteField("text", "Hi!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct{ field, name, content string }{
+		{"attachment", "a.txt", "A"},
+		{"attachments", "b.txt", "BB"},
+		{"attachments", "c.txt", "CCC"},
+	} {
+		fw, err := mw.CreateFormFile(f.field, f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(f.content))
+	}
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", url, body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return r
+}
+
+func TestRequestObjectMapper_withMultipart(t *testing.T) {
+	mux := NewServeMux()
+	mux.Middleware(HTTPRWDI())
+	mux.Middleware(RequestObjectMapper())
+	mux.Middleware(ResponseMapper())
+	mux.HandleFunc("POST", "/api/todo/{id}", func(req *TargetOfMultipart) (map[string]interface{}, error) {
+		f, err := req.Attachment.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		content, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(req.Attachments))
+		for _, fh := range req.Attachments {
+			names = append(names, fh.Filename)
+		}
+
+		return map[string]interface{}{"id": req.ID, "text": req.Text, "content": string(content), "names": names}, nil
+	})
+	mux.Prepare()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newMultipartRequest(t, "/api/todo/5"))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := strings.TrimSpace(w.Body.String()); v != `{"content":"A","id":5,"names":["b.txt","c.txt"],"text":"Hi!"}` {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestRequestObjectMapperWithOption_maxBodySize(t *testing.T) {
+	opts := &RequestObjectMapperOption{MaxBodySize: 32}
+	mux := NewServeMux()
+	mux.Middleware(HTTPRWDI())
+	mux.Middleware(RequestObjectMapperWithOption(opts))
+	// defaults are not written back to the option
+	if v := opts.MaxMemory; v != 0 {
+		t.Errorf("unexpected: %v", v)
+	}
+	mux.Middleware(ResponseMapper())
+	mux.HandleFunc("POST", "/api/todo", func(req *TargetOfMultipart) (*TargetOfMultipart, error) {
+		return req, nil
+	})
+	mux.Prepare()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newMultipartRequest(t, "/api/todo"))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+
+	r := httptest.NewRequest("POST", "/api/todo", strings.NewReader(`{"text":"Hello, world!!!!!!!!!!!!!!!!!!!!"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+
+	r = httptest.NewRequest("POST", "/api/todo", strings.NewReader(`{"text":"Hello, world!"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+}
@@ -0,0 +1,210 @@
+package ucon
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Codec encodes response objects and decodes request bodies of a media type.
+// Implement it to support formats like msgpack, CBOR or protobuf, and register it by ServeMux.RegisterCodec.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// indentMarshaler is implemented by codecs which can make human readable output for Debug.
+type indentMarshaler interface {
+	MarshalIndent(v interface{}, prefix, indent string) ([]byte, error)
+}
+
+// JSONCodec is the Codec of encoding/json.
+type JSONCodec struct{}
+
+// Marshal returns the JSON encoding of v.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// MarshalIndent is like Marshal but applies indent to format the output.
+func (JSONCodec) MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	return json.MarshalIndent(v, prefix, indent)
+}
+
+// Unmarshal parses the JSON-encoded data and stores the result in v.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// XMLCodec is the Codec of encoding/xml.
+type XMLCodec struct{}
+
+// Marshal returns the XML encoding of v.
+func (XMLCodec) Marshal(v interface{}) ([]byte, error) {
+	return xml.Marshal(v)
+}
+
+// MarshalIndent is like Marshal but applies indent to format the output.
+func (XMLCodec) MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	return xml.MarshalIndent(v, prefix, indent)
+}
+
+// Unmarshal parses the XML-encoded data and stores the result in v.
+func (XMLCodec) Unmarshal(data []byte, v interface{}) error {
+	return xml.Unmarshal(data, v)
+}
+
+// ErrUnsupportedMediaType is the error that no codec is registered for Content-Type of the request body.
+var ErrUnsupportedMediaType = newHTTPError(http.StatusUnsupportedMediaType)
+
+// ErrNotAcceptable is the error that no codec is registered for media types in Accept header of the request.
+var ErrNotAcceptable = newHTTPError(http.StatusNotAcceptable)
+
+type codecEntry struct {
+	mediaType   string
+	contentType string
+	codec       Codec
+}
+
+// defaultCodecs are used until ServeMux.RegisterCodec is called. The first one is used when the request accepts any media type.
+// XML is opt-in, because browsers accept it in preference to `*/*` and most types can not be encoded to XML, e.g. maps.
+var defaultCodecs = []*codecEntry{
+	{mediaType: "application/json", contentType: "application/json; charset=UTF-8", codec: JSONCodec{}},
+}
+
+// RegisterCodec registers the codec of the media type, only JSON is registered by default.
+// e.g. `mux.RegisterCodec("application/xml; charset=UTF-8", ucon.XMLCodec{})` enables XML.
+// The media type can have parameters like `application/json; charset=UTF-8`, it is written to Content-Type header of responses.
+// The codec of the registered media type is replaced.
+func (m *ServeMux) RegisterCodec(mediaType string, c Codec) {
+	mt, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		panic(err)
+	}
+
+	if m.codecs == nil {
+		m.codecs = append([]*codecEntry(nil), defaultCodecs...)
+	}
+	entry := &codecEntry{mediaType: mt, contentType: mediaType, codec: c}
+	for idx, e := range m.codecs {
+		if e.mediaType == mt {
+			m.codecs[idx] = entry
+			return
+		}
+	}
+	m.codecs = append(m.codecs, entry)
+}
+
+func (m *ServeMux) codecEntries() []*codecEntry {
+	if m == nil || m.codecs == nil {
+		return defaultCodecs
+	}
+	return m.codecs
+}
+
+// codecOf returns the codec registered for the media type, and nil if no codec is registered.
+func (m *ServeMux) codecOf(mediaType string) Codec {
+	for _, e := range m.codecEntries() {
+		if e.mediaType == mediaType {
+			return e.codec
+		}
+	}
+	return nil
+}
+
+// decoder returns the codec of the media type in Content-Type header.
+// Structured syntax suffixes like `application/problem+json` fall back to the codec of `application/json`.
+func (m *ServeMux) decoder(contentType string) (string, Codec) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil
+	}
+
+	if c := m.codecOf(mt); c != nil {
+		return mt, c
+	}
+	if idx := strings.LastIndex(mt, "+"); idx != -1 {
+		return mt, m.codecOf("application/" + mt[idx+1:])
+	}
+
+	return mt, nil
+}
+
+// encoders returns acceptable codecs in order of preference by Accept header.
+func (m *ServeMux) encoders(accept string) []*codecEntry {
+	entries := m.codecEntries()
+	if strings.TrimSpace(accept) == "" {
+		return entries[:1]
+	}
+
+	type acceptRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []*acceptRange
+	for _, text := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(text)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		ranges = append(ranges, &acceptRange{mediaType: mt, q: q})
+	}
+	// higher quality first, more specific range first in the same quality
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+
+	var acceptable []*codecEntry
+	found := make(map[*codecEntry]bool)
+	for _, r := range ranges {
+		for _, e := range entries {
+			if !found[e] && matchMediaRange(r.mediaType, e.mediaType) {
+				found[e] = true
+				acceptable = append(acceptable, e)
+			}
+		}
+	}
+
+	return acceptable
+}
+
+func specificity(mediaRange string) int {
+	if mediaRange == "*/*" {
+		return 0
+	} else if strings.HasSuffix(mediaRange, "/*") {
+		return 1
+	}
+	return 2
+}
+
+func matchMediaRange(mediaRange string, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1])
+	}
+	return false
+}
+
+// RegisterCodec registers the codec of the media type to DefaultMux.
+func RegisterCodec(mediaType string, c Codec) {
+	DefaultMux.RegisterCodec(mediaType, c)
+}
@@ -0,0 +1,288 @@
+package ucon
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeMuxEncoders(t *testing.T) {
+	mux := NewServeMux()
+	mux.RegisterCodec("application/xml; charset=UTF-8", XMLCodec{})
+	mux.RegisterCodec("text/xml; charset=UTF-8", XMLCodec{})
+
+	for accept, expected := range map[string]string{
+		"":                                    "application/json",
+		"*/*":                                 "application/json",
+		"application/json":                    "application/json",
+		"application/xml":                     "application/xml",
+		"text/*":                              "text/xml",
+		"application/json;q=0.5, text/xml":    "text/xml",
+		"application/xml, application/json":   "application/xml",
+		"*/*;q=0.8, application/json;q=0.8":   "application/json",
+		"text/html, application/xml;q=0.9":    "application/xml",
+		"image/png, application/json;q=0":     "",
+		"application/msgpack":                 "",
+		"APPLICATION/JSON; charset=UTF-8":     "application/json",
+		"application/json;q=invalid, */*;q=1": "application/json",
+	} {
+		entries := mux.encoders(accept)
+		if expected == "" {
+			if len(entries) != 0 {
+				t.Errorf("unexpected: %v %v", accept, entries[0].mediaType)
+			}
+			continue
+		}
+		if len(entries) == 0 || entries[0].mediaType != expected {
+			t.Errorf("unexpected: %v %v", accept, entries)
+		}
+	}
+}
+
+func TestServeMuxEncoders_default(t *testing.T) {
+	mux := NewServeMux()
+
+	// XML is not registered by default
+	for accept, expected := range map[string]string{
+		"":                "application/json",
+		"application/xml": "",
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": "application/json",
+	} {
+		entries := mux.encoders(accept)
+		if expected == "" {
+			if len(entries) != 0 {
+				t.Errorf("unexpected: %v %v", accept, entries[0].mediaType)
+			}
+			continue
+		}
+		if len(entries) == 0 || entries[0].mediaType != expected {
+			t.Errorf("unexpected: %v %v", accept, entries)
+		}
+	}
+}
+
+func TestServeMuxDecoder(t *testing.T) {
+	mux := NewServeMux()
+	mux.RegisterCodec("application/xml; charset=UTF-8", XMLCodec{})
+	mux.RegisterCodec("text/xml; charset=UTF-8", XMLCodec{})
+
+	for contentType, expected := range map[string]bool{
+		"application/json":                true,
+		"application/json; charset=UTF-8": true,
+		"application/problem+json":        true,
+		"text/xml":                        true,
+		"application/msgpack":             false,
+		"":                                false,
+	} {
+		_, codec := mux.decoder(contentType)
+		if v := codec != nil; v != expected {
+			t.Errorf("unexpected: %v %v", contentType, v)
+		}
+	}
+}
+
+func TestServeMuxCodecOf(t *testing.T) {
+	mux := NewServeMux()
+	mux.RegisterCodec("application/json; charset=UTF-8", upperCodec{})
+
+	if v, ok := mux.codecOf("application/json").(upperCodec); !ok {
+		t.Errorf("unexpected: %v", v)
+	}
+	// parameters and suffixes are not handled
+	if v := mux.codecOf("application/json; charset=UTF-8"); v != nil {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := mux.codecOf("application/problem+json"); v != nil {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+type TargetOfCodec struct {
+	XMLName xml.Name `json:"-" xml:"todo"`
+	ID      int      `json:"id" xml:"id"`
+	Text    string   `json:"text" xml:"text"`
+}
+
+// upperCodec is a dummy codec which has only a text.
+type upperCodec struct{}
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(*TargetOfCodec).Text)), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v interface{}) error {
+	v.(*TargetOfCodec).Text = strings.ToLower(string(data))
+	return nil
+}
+
+func handlerOfCodec(req *TargetOfCodec) (*TargetOfCodec, error) {
+	return req, nil
+}
+
+func TestResponseMapper_withCodecs(t *testing.T) {
+	DefaultMux = NewServeMux()
+	RegisterCodec("application/xml; charset=UTF-8", XMLCodec{})
+	Orthodox()
+
+	HandleFunc("POST", "/api/todo/{id}", handlerOfCodec)
+
+	DefaultMux.Prepare()
+
+	r := httptest.NewRequest("POST", "/api/todo/1", strings.NewReader(`<todo><text>Hi!</text></todo>`))
+	r.Header.Set("Content-Type", "application/xml")
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := w.Header().Get("Content-Type"); v != "application/json; charset=UTF-8" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Body.String(); v != `{"id":1,"text":"Hi!"}` {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	r = httptest.NewRequest("POST", "/api/todo/1", strings.NewReader(`{"text":"Hi!"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Accept", "application/xml")
+	w = httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := w.Header().Get("Content-Type"); v != "application/xml; charset=UTF-8" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Body.String(); v != `<todo><id>1</id><text>Hi!</text></todo>` {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestResponseMapper_withUnsupportedMediaType(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("POST", "/api/todo/{id}", handlerOfCodec)
+
+	DefaultMux.Prepare()
+
+	r := httptest.NewRequest("POST", "/api/todo/1", strings.NewReader(`Hi!`))
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+
+	r = httptest.NewRequest("POST", "/api/todo/1", nil)
+	r.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+}
+
+func TestResponseMapper_withNotAcceptable(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("POST", "/api/todo/{id}", handlerOfCodec)
+
+	DefaultMux.Prepare()
+
+	r := httptest.NewRequest("POST", "/api/todo/1", nil)
+	r.Header.Set("Accept", "image/png")
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := w.Header().Get("Content-Type"); v != "application/json; charset=UTF-8" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestServeMuxRegisterCodec(t *testing.T) {
+	DefaultMux = NewServeMux()
+	RegisterCodec("text/plain; charset=UTF-8", upperCodec{})
+	Orthodox()
+
+	HandleFunc("POST", "/api/todo/{id}", handlerOfCodec)
+
+	DefaultMux.Prepare()
+
+	r := httptest.NewRequest("POST", "/api/todo/1", bytes.NewBufferString("Hi!"))
+	r.Header.Set("Content-Type", "text/plain")
+	r.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := w.Header().Get("Content-Type"); v != "text/plain; charset=UTF-8" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Body.String(); v != "HI!" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	// JSON is still the default
+	r = httptest.NewRequest("POST", "/api/todo/1", nil)
+	w = httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if v := w.Header().Get("Content-Type"); v != "application/json; charset=UTF-8" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestResponseMapper_withUnencodableValue(t *testing.T) {
+	DefaultMux = NewServeMux()
+	RegisterCodec("application/xml; charset=UTF-8", XMLCodec{})
+	Orthodox()
+
+	HandleFunc("GET", "/api/todo", func() (map[string]string, error) {
+		return map[string]string{"text": "Hi!"}, nil
+	})
+
+	DefaultMux.Prepare()
+
+	// maps can not be encoded to XML, the next acceptable codec is used.
+	r := httptest.NewRequest("GET", "/api/todo", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := w.Header().Get("Content-Type"); v != "application/json; charset=UTF-8" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Body.String(); v != `{"text":"Hi!"}` {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	// the error is written once
+	r = httptest.NewRequest("GET", "/api/todo", nil)
+	r.Header.Set("Accept", "application/xml")
+	w = httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := w.Body.String(); v != `{"code":500,"message":"Internal Server Error"}` {
+		t.Errorf("unexpected: %v", v)
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
 // DefaultMux is the default ServeMux in ucon.
@@ -21,10 +25,43 @@ func NewServeMux() *ServeMux {
 
 // ServeMux is an HTTP request multiplexer.
 type ServeMux struct {
-	Debug       bool
+	Debug bool
+	// AutoOptions makes the router answer OPTIONS requests automatically with the Allow header,
+	// when no OPTIONS route is registered for the path.
+	AutoOptions bool
+	// StrictPathMatch makes path templates without a catch-all parameter never match longer paths.
+	// e.g. `/` does not match `/js/index.js`, `/{path...}` does.
+	StrictPathMatch bool
+	// StrictArguments makes Prepare panic when arguments of handlers can not be resolved by providers or built-in middlewares.
+	// They are only logged if it is false, because custom middlewares may inject those.
+	StrictArguments bool
+	// NotFoundHandler handles requests which match to no route.
+	// If it is nil, an error object of 404 is written as JSON.
+	NotFoundHandler http.Handler
+	// MethodNotAllowedHandler handles requests which match to some routes on the path but not on the method.
+	// The Allow header is already set when it is called.
+	// If it is nil, an error object of 405 is written as JSON.
+	MethodNotAllowedHandler http.Handler
+	// ErrorHandler handles errors which escape from the middleware chain.
+	// If it is nil, the error is written as JSON like ResponseMapper does.
+	// Messages of errors other than HTTPErrorResponse are hidden unless Debug is true.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+	// HeartbeatInterval is the interval of comments sent to keep Server-Sent Events alive, see EventStream.
+	// DefaultHeartbeatInterval is used if it is zero, and no comments are sent if it is negative.
+	HeartbeatInterval time.Duration
+	// RouteConflictMode is the way to report conflicts of routes at Prepare. see RouteConflicts.
+	RouteConflictMode RouteConflictMode
+
 	router      *Router
 	middlewares []MiddlewareFunc
 	plugins     []*pluginContainer
+	names       map[string]*RouteDefinition
+	providers   map[reflect.Type]*provider
+	codecs      []*codecEntry
+
+	serverMu   sync.Mutex
+	server     *http.Server
+	serverDone chan struct{}
 }
 
 // MiddlewareFunc is an adapter to hook middleware processing.
@@ -44,6 +81,18 @@ func (m *ServeMux) Plugin(plugin interface{}) {
 	}
 	p.check()
 	m.plugins = append(m.plugins, p)
+
+	if mp := p.Middleware(); mp != nil {
+		m.middlewares = append(m.middlewares, mp.Middlewares(m)...)
+	}
+	if rp := p.RouteRegistered(); rp != nil {
+		for _, rd := range m.router.handlers {
+			err := rp.RouteRegisteredProcess(m, rd)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
 }
 
 // Prepare the ServeMux.
@@ -51,18 +100,20 @@ func (m *ServeMux) Plugin(plugin interface{}) {
 // This method is enabled plugins.
 func (m *ServeMux) Prepare() {
 	for _, plugin := range m.plugins {
-		used := false
+		if !plugin.used() {
+			panic(fmt.Sprintf("unused plugin: %#v", plugin))
+		}
 		if sc := plugin.HandlersScanner(); sc != nil {
 			err := sc.HandlersScannerProcess(m, m.router.handlers)
 			if err != nil {
 				panic(err)
 			}
-			used = true
-		}
-		if !used {
-			panic(fmt.Sprintf("unused plugin: %#v", plugin))
 		}
 	}
+
+	m.checkRouteConflicts()
+	m.checkHandlerArguments()
+	m.router.prepare()
 }
 
 // ServeHTTP dispatches request to the handler.
@@ -75,36 +126,81 @@ func (m *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	m.router.ServeHTTP(w, r)
 }
 
-// ListenAndServe start accepts the client request.
-func (m *ServeMux) ListenAndServe(addr string) error {
-	m.Prepare()
-
-	server := &http.Server{Addr: addr, Handler: m}
-	return server.ListenAndServe()
+// Handle register the HandlerContainer for the given method & path to the ServeMux.
+func (m *ServeMux) Handle(method string, path string, hc HandlerContainer, opts ...RouteOption) {
+	m.handle(nil, method, path, hc, opts)
 }
 
-// Handle register the HandlerContainer for the given method & path to the ServeMux.
-func (m *ServeMux) Handle(method string, path string, hc HandlerContainer) {
+func (m *ServeMux) handle(g *RouteGroup, method string, path string, hc HandlerContainer, opts []RouteOption) {
 	CheckFunction(hc.Handler())
 
 	pathTmpl := ParsePathTemplate(path)
+	plan := getHandlerPlan(reflect.TypeOf(hc.Handler()))
 	methods := strings.Split(strings.ToUpper(method), ",")
+	var named *RouteDefinition
 	for _, method := range methods {
 		rd := &RouteDefinition{
 			Method:           method,
 			PathTemplate:     pathTmpl,
 			HandlerContainer: hc,
+			group:            g,
+			plan:             plan,
+		}
+		for _, opt := range opts {
+			opt(rd)
+		}
+		if rd.Name != "" && named == nil {
+			named = rd
 		}
 		m.router.addRoute(rd)
+
+		for _, plugin := range m.plugins {
+			if rp := plugin.RouteRegistered(); rp != nil {
+				err := rp.RouteRegisteredProcess(m, rd)
+				if err != nil {
+					panic(err)
+				}
+			}
+		}
+	}
+
+	if named != nil {
+		if _, ok := m.names[named.Name]; ok {
+			panic(fmt.Sprintf("duplicated route name: %s", named.Name))
+		}
+		if m.names == nil {
+			m.names = make(map[string]*RouteDefinition)
+		}
+		m.names[named.Name] = named
 	}
 }
 
 // HandleFunc register the handler function for the given method & path to the ServeMux.
-func (m *ServeMux) HandleFunc(method string, path string, h interface{}) {
+func (m *ServeMux) HandleFunc(method string, path string, h interface{}, opts ...RouteOption) {
 	m.Handle(method, path, &handlerContainerImpl{
 		handler: h,
 		Context: background,
-	})
+	}, opts...)
+}
+
+// URL builds the URL of the route which is registered with the name by WithName.
+// The path parameters are substituted by params and escaped, and query is appended if it is not empty.
+// It returns an error if the name is unknown or a parameter is missing or does not satisfy its constraint.
+func (m *ServeMux) URL(name string, params map[string]string, query url.Values) (string, error) {
+	rd, ok := m.names[name]
+	if !ok {
+		return "", fmt.Errorf("unknown route name: %s", name)
+	}
+
+	u, err := rd.PathTemplate.Expand(params)
+	if err != nil {
+		return "", err
+	}
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+
+	return u, nil
 }
 
 func (m *ServeMux) newBubble(c context.Context, w http.ResponseWriter, r *http.Request, rd *RouteDefinition) (*Bubble, error) {
@@ -113,15 +209,86 @@ func (m *ServeMux) newBubble(c context.Context, w http.ResponseWriter, r *http.R
 		W:              w,
 		Context:        c,
 		RequestHandler: rd.HandlerContainer,
+		route:          rd,
 	}
 	err := b.init(m)
 	if err != nil {
-		return nil, err
+		// the bubble is returned to pass it to plugins which hook errors.
+		return b, err
 	}
 
 	return b, nil
 }
 
+// serveBubble runs the middleware chain of the bubble with plugins which hook requests.
+func (m *ServeMux) serveBubble(b *Bubble) {
+	defer func() {
+		for _, plugin := range m.plugins {
+			if p := plugin.RequestEnd(); p != nil {
+				p.RequestEndProcess(b)
+			}
+		}
+	}()
+
+	var err error
+	for _, plugin := range m.plugins {
+		if p := plugin.RequestStart(); p != nil {
+			err = p.RequestStartProcess(b)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = b.Next()
+	}
+	if err == nil {
+		return
+	}
+
+	m.handleBubbleError(b, err)
+}
+
+// handleBubbleError runs plugins which hook errors, then handles the error escaped from the bubble.
+func (m *ServeMux) handleBubbleError(b *Bubble, err error) {
+	for _, plugin := range m.plugins {
+		if p := plugin.RequestError(); p != nil {
+			p.RequestErrorProcess(b, err)
+		}
+	}
+	m.handleError(b.W, b.R, err)
+}
+
+func (m *ServeMux) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	if m.NotFoundHandler != nil {
+		m.NotFoundHandler.ServeHTTP(w, r)
+		return
+	}
+
+	writeErrorObject(w, newHTTPError(http.StatusNotFound), m.Debug)
+}
+
+func (m *ServeMux) handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	if m.MethodNotAllowedHandler != nil {
+		m.MethodNotAllowedHandler.ServeHTTP(w, r)
+		return
+	}
+
+	writeErrorObject(w, newHTTPError(http.StatusMethodNotAllowed), m.Debug)
+}
+
+func (m *ServeMux) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if m.ErrorHandler != nil {
+		m.ErrorHandler(w, r, err)
+		return
+	}
+
+	if _, ok := err.(HTTPErrorResponse); !ok && !m.Debug {
+		err = newHTTPError(http.StatusInternalServerError)
+	}
+	writeErrorObject(w, err, m.Debug)
+}
+
 // HandlerContainer is handler function container.
 // and It has a ucon Context that make it possible communicate to Plugins.
 type HandlerContainer interface {
@@ -129,8 +296,25 @@ type HandlerContainer interface {
 	Context
 }
 
+// MiddlewareContainer is an optional interface of HandlerContainer to carry its own middlewares.
+// Those middlewares run only for the handler, after middlewares of ServeMux and RouteGroup.
+type MiddlewareContainer interface {
+	Middlewares() []MiddlewareFunc
+}
+
+// NewHandlerContainer returns a HandlerContainer which has the handler function and its own middlewares.
+func NewHandlerContainer(h interface{}, middlewares ...MiddlewareFunc) HandlerContainer {
+	CheckFunction(h)
+	return &handlerContainerImpl{
+		handler:     h,
+		middlewares: middlewares,
+		Context:     background,
+	}
+}
+
 type handlerContainerImpl struct {
-	handler interface{}
+	handler     interface{}
+	middlewares []MiddlewareFunc
 	Context
 }
 
@@ -138,6 +322,10 @@ func (hc *handlerContainerImpl) Handler() interface{} {
 	return hc.handler
 }
 
+func (hc *handlerContainerImpl) Middlewares() []MiddlewareFunc {
+	return hc.middlewares
+}
+
 // Orthodox middlewares enable to DefaultServeMux.
 func Orthodox() {
 	DefaultMux.Middleware(ResponseMapper())
@@ -151,22 +339,32 @@ func Middleware(f MiddlewareFunc) {
 	DefaultMux.Middleware(f)
 }
 
+// Group returns a new RouteGroup of DefaultMux for the given path prefix.
+func Group(prefix string) *RouteGroup {
+	return DefaultMux.Group(prefix)
+}
+
 // Plugin can append Plugin to ServeMux.
 func Plugin(plugin interface{}) {
 	DefaultMux.Plugin(plugin)
 }
 
 // ListenAndServe start accepts the client request.
-func ListenAndServe(addr string) {
-	DefaultMux.ListenAndServe(addr)
+func ListenAndServe(addr string, opts ...ServerOption) {
+	DefaultMux.ListenAndServe(addr, opts...)
 }
 
 // Handle register the HandlerContainer for the given method & path to the ServeMux.
-func Handle(method string, path string, hc HandlerContainer) {
-	DefaultMux.Handle(method, path, hc)
+func Handle(method string, path string, hc HandlerContainer, opts ...RouteOption) {
+	DefaultMux.Handle(method, path, hc, opts...)
 }
 
 // HandleFunc register the handler function for the given method & path to the ServeMux.
-func HandleFunc(method string, path string, h interface{}) {
-	DefaultMux.HandleFunc(method, path, h)
+func HandleFunc(method string, path string, h interface{}, opts ...RouteOption) {
+	DefaultMux.HandleFunc(method, path, h, opts...)
+}
+
+// URL builds the URL of the named route in DefaultMux.
+func URL(name string, params map[string]string, query url.Values) (string, error) {
+	return DefaultMux.URL(name, params, query)
 }
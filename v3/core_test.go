@@ -1,6 +1,10 @@
 package ucon
 
-import "testing"
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
 
 func TestMiddleware(t *testing.T) {
 	DefaultMux = NewServeMux()
@@ -114,3 +118,134 @@ func TestUconContextWithValue(t *testing.T) {
 		t.Fatalf("unexpected: %v", v)
 	}
 }
+
+func TestHandleWithHandlerMiddlewares(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	var called []string
+	record := func(name string) MiddlewareFunc {
+		return func(b *Bubble) error {
+			called = append(called, name)
+			return b.Next()
+		}
+	}
+
+	Middleware(record("mux"))
+
+	g := Group("/api")
+	g.Middleware(record("group"))
+	g.Handle("POST", "/todo", NewHandlerContainer(func() {}, record("route1"), record("route2")))
+	g.HandleFunc("GET", "/todo", func() {})
+
+	DefaultMux.Prepare()
+
+	if v := len(DefaultMux.router.handlers[0].Middlewares()); v != 3 {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := len(DefaultMux.router.handlers[1].Middlewares()); v != 1 {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	resp := MakeHandlerTestBed(t, "POST", "/api/todo", nil)
+	if v := resp.StatusCode; v != 200 {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := strings.Join(called, ","); v != "mux,group,route1,route2" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	called = nil
+	resp = MakeHandlerTestBed(t, "GET", "/api/todo", nil)
+	if v := resp.StatusCode; v != 200 {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := strings.Join(called, ","); v != "mux,group" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestServeMuxURL(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	h := func() {}
+
+	HandleFunc("GET,PUT", "/api/todo/{id:int}", h, WithName("todo"))
+	HandleFunc("GET", "/api/user/{name}/comments/{cid}", h, WithName("comment"))
+	HandleFunc("GET", "/static/{path...}", h, WithName("static"))
+	HandleFunc("GET", "/api/todo", h, WithName("todos"))
+	Group("/admin").HandleFunc("GET", "/users/{id}", h, WithName("admin.user"))
+
+	cases := []struct {
+		name     string
+		params   map[string]string
+		query    url.Values
+		expected string
+	}{
+		{"todo", map[string]string{"id": "1"}, nil, "/api/todo/1"},
+		{"todo", map[string]string{"id": "1"}, url.Values{"offset": []string{"10"}}, "/api/todo/1?offset=10"},
+		{"comment", map[string]string{"name": "a b/c+d", "cid": "2"}, nil, "/api/user/a%20b%2Fc%2Bd/comments/2"},
+		{"static", map[string]string{"path": "js/index file.js"}, nil, "/static/js/index%20file.js"},
+		{"static", map[string]string{"path": ""}, nil, "/static/"},
+		{"todos", nil, nil, "/api/todo"},
+		{"admin.user", map[string]string{"id": "1"}, nil, "/admin/users/1"},
+	}
+	for _, c := range cases {
+		u, err := URL(c.name, c.params, c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != c.expected {
+			t.Errorf("unexpected: %v", u)
+		}
+	}
+
+	failures := []struct {
+		name   string
+		params map[string]string
+	}{
+		{"unknown", nil},
+		{"todo", nil},
+		{"todo", map[string]string{"id": ""}},
+		{"todo", map[string]string{"id": "abc"}},
+		{"comment", map[string]string{"name": "a"}},
+	}
+	for _, c := range failures {
+		if _, err := URL(c.name, c.params, nil); err == nil {
+			t.Errorf("unexpected: %v %v", c.name, c.params)
+		}
+	}
+}
+
+func TestServeMuxURL_roundTrip(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	HandleFunc("GET", "/api/user/{name}/{path...}", func() {}, WithName("user"))
+
+	params := map[string]string{"name": "a b/c+d%", "path": "x/y z"}
+	u, err := URL("user", params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, actual := DefaultMux.names["user"].PathTemplate.Match(u)
+	if !match {
+		t.Fatalf("unexpected: %v", u)
+	}
+	for k, v := range params {
+		if actual[k] != v {
+			t.Errorf("unexpected: %v", actual[k])
+		}
+	}
+}
+
+func TestHandle_duplicatedName(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	HandleFunc("GET", "/api/a", func() {}, WithName("a"))
+
+	defer func() {
+		if err := recover(); err == nil {
+			t.Errorf("unexpected: %v", err)
+		}
+	}()
+	HandleFunc("GET", "/api/b", func() {}, WithName("a"))
+}
@@ -0,0 +1,240 @@
+package ucon
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ProviderScope is the lifetime of values made by a provider.
+type ProviderScope int
+
+const (
+	// SingletonScope provider is called once per ServeMux, and the value is shared between requests.
+	// It is called again by the next request if it returns an error.
+	SingletonScope ProviderScope = iota
+	// RequestScope provider is called once per request.
+	RequestScope
+)
+
+var bubbleType = reflect.TypeOf((*Bubble)(nil))
+
+type provider struct {
+	scope      ProviderScope
+	typ        reflect.Type
+	fn         reflect.Value
+	withBubble bool
+	withError  bool
+
+	mu       sync.Mutex
+	provided bool
+	value    reflect.Value
+}
+
+// Provide registers the provider function of handler arguments.
+// The provider must be one of `func() T`, `func() (T, error)`, `func(b *Bubble) T` or `func(b *Bubble) (T, error)`,
+// and T is injected into handler arguments whose type is exactly T. Providers of SingletonScope can not take *Bubble,
+// because the value outlives the request.
+// Provided values are resolved just before the handler is called, so providers can use values set by middlewares.
+// It panics if the provider is invalid or T is already provided.
+func (m *ServeMux) Provide(scope ProviderScope, fn interface{}) {
+	fv := reflect.ValueOf(fn)
+	fT := fv.Type()
+	if fT.Kind() != reflect.Func {
+		panic("provider is not function")
+	}
+
+	p := &provider{scope: scope, fn: fv}
+	switch {
+	case fT.NumIn() == 0:
+	case fT.NumIn() == 1 && fT.In(0) == bubbleType:
+		if scope == SingletonScope {
+			panic(fmt.Sprintf("singleton provider can not take *Bubble: %s", fT.String()))
+		}
+		p.withBubble = true
+	default:
+		panic(fmt.Sprintf("provider can only take *Bubble: %s", fT.String()))
+	}
+	switch {
+	case fT.NumOut() == 1:
+	case fT.NumOut() == 2 && fT.Out(1) == errorType:
+		p.withError = true
+	default:
+		panic(fmt.Sprintf("provider must return a value and an optional error: %s", fT.String()))
+	}
+	p.typ = fT.Out(0)
+
+	m.addProvider(p)
+}
+
+// ProvideValue registers the value as a singleton of its type.
+func (m *ServeMux) ProvideValue(v interface{}) {
+	p := &provider{
+		scope:    SingletonScope,
+		typ:      reflect.TypeOf(v),
+		provided: true,
+		value:    reflect.ValueOf(v),
+	}
+
+	m.addProvider(p)
+}
+
+func (m *ServeMux) addProvider(p *provider) {
+	if _, ok := m.providers[p.typ]; ok {
+		panic(fmt.Sprintf("duplicated provider: %s", p.typ.String()))
+	}
+	if m.providers == nil {
+		m.providers = make(map[reflect.Type]*provider)
+	}
+	m.providers[p.typ] = p
+}
+
+func (p *provider) provide(b *Bubble) (reflect.Value, error) {
+	if p.scope == SingletonScope {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if !p.provided {
+			v, err := p.call(b)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			p.value = v
+			p.provided = true
+		}
+		return p.value, nil
+	}
+
+	if v, ok := b.provided[p.typ]; ok {
+		return v, nil
+	}
+	v, err := p.call(b)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if b.provided == nil {
+		b.provided = make(map[reflect.Type]reflect.Value)
+	}
+	b.provided[p.typ] = v
+
+	return v, nil
+}
+
+func (p *provider) call(b *Bubble) (reflect.Value, error) {
+	var in []reflect.Value
+	if p.withBubble {
+		in = []reflect.Value{reflect.ValueOf(b)}
+	}
+
+	out := p.fn.Call(in)
+	if p.withError && !out[1].IsNil() {
+		return reflect.Value{}, out[1].Interface().(error)
+	}
+
+	return out[0], nil
+}
+
+// Resolve returns the value of the type made by the provider registered to ServeMux.
+// Values of RequestScope are shared in the bubble, so middlewares can use the same value as the handler.
+func (b *Bubble) Resolve(t reflect.Type) (reflect.Value, error) {
+	p := b.mux.provider(t)
+	if p == nil {
+		return reflect.Value{}, fmt.Errorf("no provider for %s", t.String())
+	}
+
+	return p.provide(b)
+}
+
+// resolveArguments injects LastEventID and provided values into arguments which are not injected by middlewares.
+func (b *Bubble) resolveArguments() error {
+	for _, idx := range b.handlerPlan().lastEventIDSlots {
+		if !b.Arguments[idx].IsValid() {
+			b.Arguments[idx] = reflect.ValueOf(LastEventID(b.R.Header.Get("Last-Event-ID")))
+		}
+	}
+
+	if b.mux == nil || len(b.mux.providers) == 0 {
+		return nil
+	}
+
+	for idx, arg := range b.Arguments {
+		if arg.IsValid() {
+			continue
+		}
+		p := b.mux.provider(b.ArgumentTypes[idx])
+		if p == nil {
+			continue
+		}
+		v, err := p.provide(b)
+		if err != nil {
+			return err
+		}
+		b.Arguments[idx] = v
+	}
+
+	return nil
+}
+
+func (m *ServeMux) provider(t reflect.Type) *provider {
+	if m == nil {
+		return nil
+	}
+	return m.providers[t]
+}
+
+// checkHandlerArguments reports arguments of handlers which can not be resolved.
+// Resolvable arguments are provided types, types injected by built-in middlewares and a request object.
+// It panics if StrictArguments is true, otherwise it only logs them because custom middlewares may inject those.
+func (m *ServeMux) checkHandlerArguments() {
+	var messages []string
+	checked := make(map[HandlerContainer]bool)
+	for _, rd := range m.router.handlers {
+		if checked[rd.HandlerContainer] || rd.Mounted() {
+			continue
+		}
+		checked[rd.HandlerContainer] = true
+
+		requestObject := false
+		plan := getHandlerPlan(reflect.TypeOf(rd.HandlerContainer.Handler()))
+		for idx, argT := range plan.argumentTypes {
+			if m.provider(argT) != nil {
+				continue
+			}
+			if httpReqType.AssignableTo(argT) || httpRespType.AssignableTo(argT) || contextType.AssignableTo(argT) || argT == lastEventIDType || argT == wsConnType {
+				continue
+			}
+			if argT.Kind() == reflect.Ptr && argT.Elem().Kind() == reflect.Struct {
+				if plan.sources[idx] != sourceAll {
+					continue
+				}
+				if !requestObject {
+					requestObject = true
+					continue
+				}
+			}
+			messages = append(messages, fmt.Sprintf("%s %s: %s", rd.Method, rd.PathTemplate.PathTemplate, argT.String()))
+		}
+	}
+
+	if len(messages) == 0 {
+		return
+	}
+	if m.StrictArguments {
+		panic(fmt.Sprintf("unresolvable handler arguments are found:\n%s", strings.Join(messages, "\n")))
+	}
+	for _, message := range messages {
+		log.Printf("[ucon] unresolvable handler argument %s", message)
+	}
+}
+
+// Provide registers the provider function of handler arguments to DefaultMux.
+func Provide(scope ProviderScope, fn interface{}) {
+	DefaultMux.Provide(scope, fn)
+}
+
+// ProvideValue registers the value as a singleton of its type to DefaultMux.
+func ProvideValue(v interface{}) {
+	DefaultMux.ProvideValue(v)
+}
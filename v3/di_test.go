@@ -0,0 +1,274 @@
+package ucon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type DatabaseOfDI struct {
+	Name string
+}
+
+type UserOfDI struct {
+	Name string
+}
+
+type LoggerOfDI interface {
+	Log(msg string)
+}
+
+type loggerOfDI struct {
+	logs []string
+}
+
+func (l *loggerOfDI) Log(msg string) {
+	l.logs = append(l.logs, msg)
+}
+
+type userKeyOfDI struct{}
+
+func TestServeMuxProvide(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	db := &DatabaseOfDI{Name: "db"}
+	logger := &loggerOfDI{}
+	singletonCalled := 0
+	requestCalled := 0
+
+	ProvideValue(db)
+	Provide(SingletonScope, func() LoggerOfDI {
+		singletonCalled++
+		return logger
+	})
+	Provide(RequestScope, func(b *Bubble) (*UserOfDI, error) {
+		requestCalled++
+		user, ok := b.Context.Value(userKeyOfDI{}).(*UserOfDI)
+		if !ok {
+			return nil, &httpError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		}
+		return user, nil
+	})
+
+	Orthodox()
+	// the authentication middleware runs after the bubble is made, providers can use its result.
+	Middleware(func(b *Bubble) error {
+		if name := b.R.Header.Get("X-User"); name != "" {
+			b.Context = context.WithValue(b.Context, userKeyOfDI{}, &UserOfDI{Name: name})
+		}
+		v, err := b.Resolve(reflect.TypeOf(&UserOfDI{}))
+		if err != nil {
+			return err
+		}
+		v.Interface().(*UserOfDI).Name += "!"
+		return b.Next()
+	})
+
+	HandleFunc("PUT", "/api/todo/{id}", func(db *DatabaseOfDI, l LoggerOfDI, user *UserOfDI, req *RequestOfRoutingInfoAddHandlers) (*ResponseOfRoutingInfoAddHandlers, error) {
+		l.Log(user.Name)
+		return &ResponseOfRoutingInfoAddHandlers{Text: db.Name + ":" + user.Name + ":" + req.Text}, nil
+	})
+
+	DefaultMux.Prepare()
+
+	for _, name := range []string{"alice", "bob"} {
+		req, err := http.NewRequest("PUT", "/api/todo/1", strings.NewReader(`{"text":"hi"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User", name)
+		w := httptest.NewRecorder()
+		DefaultMux.ServeHTTP(w, req)
+
+		if v := w.Code; v != http.StatusOK {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := w.Body.String(); v != `{"text":"db:`+name+`!:hi"}` {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+
+	if v := singletonCalled; v != 1 {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := requestCalled; v != 2 {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := strings.Join(logger.logs, ","); v != "alice!,bob!" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	resp := MakeHandlerTestBed(t, "PUT", "/api/todo/1", strings.NewReader(`{"text":"hi"}`))
+	if v := resp.StatusCode; v != http.StatusUnauthorized {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(body); v != `{"code":401,"message":"unauthorized"}` {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestServeMuxProvide_invalidProvider(t *testing.T) {
+	cases := []interface{}{
+		"not function",
+		func(s string) *UserOfDI { return nil },
+		func() {},
+		func() (*UserOfDI, string) { return nil, "" },
+	}
+	for _, c := range cases {
+		func() {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Errorf("unexpected: %v", err)
+				}
+			}()
+			NewServeMux().Provide(RequestScope, c)
+		}()
+	}
+
+	func() {
+		defer func() {
+			if err := recover(); err == nil {
+				t.Errorf("unexpected: %v", err)
+			}
+		}()
+		mux := NewServeMux()
+		mux.ProvideValue(&UserOfDI{})
+		mux.Provide(SingletonScope, func() *UserOfDI { return nil })
+	}()
+
+	func() {
+		defer func() {
+			if err := recover(); err == nil {
+				t.Errorf("unexpected: %v", err)
+			}
+		}()
+		// the singleton must not depend on the first request
+		NewServeMux().Provide(SingletonScope, func(b *Bubble) *UserOfDI { return nil })
+	}()
+}
+
+func TestServeMuxProvide_singletonError(t *testing.T) {
+	mux := NewServeMux()
+	called := 0
+	mux.Provide(SingletonScope, func() (*DatabaseOfDI, error) {
+		called++
+		if called == 1 {
+			return nil, errors.New("unavailable")
+		}
+		return &DatabaseOfDI{Name: "db"}, nil
+	})
+
+	b := &Bubble{mux: mux}
+	_, err := b.Resolve(reflect.TypeOf(&DatabaseOfDI{}))
+	if err == nil || err.Error() != "unavailable" {
+		t.Errorf("unexpected: %v", err)
+	}
+	// retried after the error, and cached after the success
+	for i := 0; i < 2; i++ {
+		v, err := b.Resolve(reflect.TypeOf(&DatabaseOfDI{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := v.Interface().(*DatabaseOfDI).Name; v != "db" {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+	if called != 2 {
+		t.Errorf("unexpected: %v", called)
+	}
+}
+
+func TestServeMuxPrepare_unresolvableArguments(t *testing.T) {
+	DefaultMux = NewServeMux()
+	DefaultMux.StrictArguments = true
+	Orthodox()
+	ProvideValue(&DatabaseOfDI{})
+
+	HandleFunc("GET", "/api/ok", func(c context.Context, w http.ResponseWriter, r *http.Request, db *DatabaseOfDI, req *RequestOfRoutingInfoAddHandlers) {})
+	HandleFunc("GET", "/api/ng", func(db *DatabaseOfDI, user *UserOfDI, req *RequestOfRoutingInfoAddHandlers, l LoggerOfDI) {})
+
+	defer func() {
+		err := recover()
+		if err == nil {
+			t.Fatalf("unexpected: %v", err)
+		}
+		msg := err.(string)
+		if !strings.Contains(msg, "GET /api/ng: *ucon.RequestOfRoutingInfoAddHandlers") || !strings.Contains(msg, "GET /api/ng: ucon.LoggerOfDI") {
+			t.Errorf("unexpected: %v", msg)
+		}
+		if strings.Contains(msg, "/api/ok") {
+			t.Errorf("unexpected: %v", msg)
+		}
+	}()
+	DefaultMux.Prepare()
+}
+
+func TestServeMuxPrepare_unresolvableArgumentsNotStrict(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	DefaultMux = NewServeMux()
+	Orthodox()
+	// custom middlewares may inject it, so it is only logged
+	Middleware(func(b *Bubble) error {
+		for idx, argT := range b.ArgumentTypes {
+			if argT == reflect.TypeOf(&UserOfDI{}) {
+				b.Arguments[idx] = reflect.ValueOf(&UserOfDI{Name: "foo"})
+			}
+		}
+		return b.Next()
+	})
+	ProvideValue(&DatabaseOfDI{})
+
+	HandleFunc("GET", "/api/user", func(req *RequestOfRoutingInfoAddHandlers, user *UserOfDI, db *DatabaseOfDI) (*ResponseOfRoutingInfoAddHandlers, error) {
+		return &ResponseOfRoutingInfoAddHandlers{Text: user.Name}, nil
+	})
+	DefaultMux.Prepare()
+
+	if v := buf.String(); !strings.Contains(v, "GET /api/user: *ucon.UserOfDI") {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	resp := MakeHandlerTestBed(t, "GET", "/api/user", nil)
+	if v := resp.StatusCode; v != http.StatusOK {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestMakeMiddlewareTestBed_withProvidedValues(t *testing.T) {
+	logger := &loggerOfDI{}
+	b, _ := MakeMiddlewareTestBed(t, ContextDI(), func(c context.Context, db *DatabaseOfDI, l LoggerOfDI) {
+		l.Log(db.Name)
+	}, &BubbleTestOption{
+		Method:         "GET",
+		URL:            "/api/tmp",
+		ProvidedValues: []interface{}{&DatabaseOfDI{Name: "mock"}},
+		Providers: []interface{}{func() (LoggerOfDI, error) {
+			if logger == nil {
+				return nil, errors.New("no logger")
+			}
+			return logger, nil
+		}},
+	})
+	err := b.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := strings.Join(logger.logs, ","); v != "mock" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
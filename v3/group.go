@@ -0,0 +1,84 @@
+package ucon
+
+import "strings"
+
+// RouteGroup is a set of routes which have a common path prefix.
+// Middlewares of RouteGroup run after middlewares of ServeMux, and only for routes registered through the group.
+type RouteGroup struct {
+	mux         *ServeMux
+	parent      *RouteGroup
+	prefix      string
+	middlewares []MiddlewareFunc
+}
+
+// Group returns a new RouteGroup for the given path prefix.
+func (m *ServeMux) Group(prefix string) *RouteGroup {
+	return &RouteGroup{
+		mux:    m,
+		prefix: prefix,
+	}
+}
+
+// Group returns a new nested RouteGroup for the given path prefix.
+// Middlewares of the parent group run before middlewares of the nested group.
+func (g *RouteGroup) Group(prefix string) *RouteGroup {
+	return &RouteGroup{
+		mux:    g.mux,
+		parent: g,
+		prefix: joinPath(g.prefix, prefix),
+	}
+}
+
+// Prefix returns the path prefix of the group.
+func (g *RouteGroup) Prefix() string {
+	return g.prefix
+}
+
+// Middleware can append Middleware to RouteGroup.
+func (g *RouteGroup) Middleware(f MiddlewareFunc) {
+	g.middlewares = append(g.middlewares, f)
+}
+
+// Handle register the HandlerContainer for the given method & path to the RouteGroup.
+// The path is joined to the prefix of the group.
+func (g *RouteGroup) Handle(method string, path string, hc HandlerContainer, opts ...RouteOption) {
+	g.mux.handle(g, method, joinPath(g.prefix, path), hc, opts)
+}
+
+// HandleFunc register the handler function for the given method & path to the RouteGroup.
+// The path is joined to the prefix of the group.
+func (g *RouteGroup) HandleFunc(method string, path string, h interface{}, opts ...RouteOption) {
+	g.Handle(method, path, &handlerContainerImpl{
+		handler: h,
+		Context: background,
+	}, opts...)
+}
+
+// middleware returns the idx-th middleware in the chain of the group and its ancestors.
+// If idx is out of the chain, it returns nil and the remaining index.
+func (g *RouteGroup) middleware(idx int) (MiddlewareFunc, int) {
+	if g.parent != nil {
+		var m MiddlewareFunc
+		m, idx = g.parent.middleware(idx)
+		if m != nil {
+			return m, 0
+		}
+	}
+
+	if idx < len(g.middlewares) {
+		return g.middlewares[idx], 0
+	}
+
+	return nil, idx - len(g.middlewares)
+}
+
+func joinPath(prefix string, path string) string {
+	if prefix == "" {
+		return path
+	}
+	if path == "" {
+		return prefix
+	}
+
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,126 @@
+package ucon
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRouteGroupHandle(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	h := func() {}
+
+	admin := Group("/admin")
+	admin.HandleFunc("GET", "/users", h)
+	admin.HandleFunc("GET", "/", h)
+
+	nested := admin.Group("/settings/")
+	nested.HandleFunc("PUT", "/{key}", h)
+
+	if v := len(DefaultMux.router.handlers); v != 3 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := DefaultMux.router.handlers[0].PathTemplate.PathTemplate; v != "/admin/users" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := DefaultMux.router.handlers[1].PathTemplate.PathTemplate; v != "/admin/" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := DefaultMux.router.handlers[2].PathTemplate.PathTemplate; v != "/admin/settings/{key}" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := nested.Prefix(); v != "/admin/settings/" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestRouteGroupMiddleware(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	var called []string
+	record := func(name string) MiddlewareFunc {
+		return func(b *Bubble) error {
+			called = append(called, name)
+			return b.Next()
+		}
+	}
+
+	Middleware(record("mux"))
+	Orthodox()
+
+	admin := Group("/admin")
+	admin.Middleware(record("admin"))
+	admin.HandleFunc("GET", "/users", func() (*ResponseOfRoutingInfoAddHandlers, error) {
+		return &ResponseOfRoutingInfoAddHandlers{Text: "admin"}, nil
+	})
+
+	nested := admin.Group("/settings")
+	nested.Middleware(record("settings"))
+	nested.HandleFunc("GET", "/{id}", func() (*ResponseOfRoutingInfoAddHandlers, error) {
+		return &ResponseOfRoutingInfoAddHandlers{Text: "settings"}, nil
+	})
+
+	HandleFunc("GET", "/api/users", func() (*ResponseOfRoutingInfoAddHandlers, error) {
+		return &ResponseOfRoutingInfoAddHandlers{Text: "api"}, nil
+	})
+
+	DefaultMux.Prepare()
+
+	cases := []struct {
+		path   string
+		called string
+		body   string
+	}{
+		{"/api/users", "mux", `{"text":"api"}`},
+		{"/admin/users", "mux,admin", `{"text":"admin"}`},
+		{"/admin/settings/1", "mux,admin,settings", `{"text":"settings"}`},
+	}
+	for _, c := range cases {
+		called = nil
+
+		resp := MakeHandlerTestBed(t, "GET", c.path, nil)
+		if v := resp.StatusCode; v != http.StatusOK {
+			t.Errorf("unexpected: %v", v)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := string(body); v != c.body {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := strings.Join(called, ","); v != c.called {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+}
+
+type TargetOfRouteGroupScannerPlugin struct {
+	rds []*RouteDefinition
+}
+
+func (obj *TargetOfRouteGroupScannerPlugin) HandlersScannerProcess(m *ServeMux, rds []*RouteDefinition) error {
+	obj.rds = rds
+	return nil
+}
+
+func TestRouteGroupWithHandlersScannerPlugin(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	plugin := &TargetOfRouteGroupScannerPlugin{}
+	Plugin(plugin)
+
+	HandleFunc("GET", "/api/test", func() {})
+	Group("/admin").HandleFunc("GET", "/test", func() {})
+
+	DefaultMux.Prepare()
+
+	if v := len(plugin.rds); v != 2 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := plugin.rds[1].PathTemplate.PathTemplate; v != "/admin/test" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
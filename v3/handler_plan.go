@@ -0,0 +1,329 @@
+package ucon
+
+import (
+	"reflect"
+	"sync"
+)
+
+// handlerPlan is metadata of a request handler function computed once per type.
+// Bubble and built-in middlewares use it instead of reflecting the handler on each request.
+// It must be treated as read-only because it is shared between requests.
+type handlerPlan struct {
+	argumentTypes []reflect.Type
+
+	// slots are indexes of arguments to be injected.
+	httpRequestSlots  []int
+	httpResponseSlots []int
+	contextSlots      []int
+	lastEventIDSlots  []int
+	wsConnSlots       []int
+	// structSlots are indexes of pointer to struct arguments, candidates of RequestObjectMapper.
+	structSlots []int
+	// sources are binding sources of struct arguments given by markers like InPath.
+	sources       []bindingSource
+	hasPathSource bool
+
+	bindings []*structBinding
+}
+
+// structBinding is a map from keys of request parameters to fields of the struct.
+type structBinding struct {
+	fields map[string]*fieldBinding
+	// headers and cookies are fields tagged as `swagger:",in=header"` or `swagger:",in=cookie"`.
+	// They are not in fields, and they are cleared after decoding the body, so other sources never fill them.
+	headers map[string]*fieldBinding
+	cookies map[string]*fieldBinding
+	// files are fields of *File or []*File, they are filled from multipart/form-data.
+	files map[string]*fieldBinding
+}
+
+type fieldBinding struct {
+	index []int
+	// stringParser is true if the field implements StringParser.
+	stringParser bool
+	// elemStringParser is true if the field is a slice whose element implements StringParser.
+	elemStringParser bool
+	isSlice          bool
+}
+
+var handlerPlanCache sync.Map // map[reflect.Type]*handlerPlan
+
+// getHandlerPlan returns the plan of the handler function type.
+func getHandlerPlan(hT reflect.Type) *handlerPlan {
+	if v, ok := handlerPlanCache.Load(hT); ok {
+		return v.(*handlerPlan)
+	}
+
+	plan := newHandlerPlan(hT)
+	v, _ := handlerPlanCache.LoadOrStore(hT, plan)
+
+	return v.(*handlerPlan)
+}
+
+func newHandlerPlan(hT reflect.Type) *handlerPlan {
+	argumentTypes := make([]reflect.Type, hT.NumIn())
+	for idx := range argumentTypes {
+		argumentTypes[idx] = hT.In(idx)
+	}
+
+	return newArgumentsPlan(argumentTypes)
+}
+
+// newArgumentsPlan returns the plan of the argument types, the slice is owned by the plan.
+func newArgumentsPlan(argumentTypes []reflect.Type) *handlerPlan {
+	numIn := len(argumentTypes)
+	plan := &handlerPlan{
+		argumentTypes: argumentTypes,
+		sources:       make([]bindingSource, numIn),
+		bindings:      make([]*structBinding, numIn),
+	}
+	for idx, argT := range argumentTypes {
+
+		if httpReqType.AssignableTo(argT) {
+			plan.httpRequestSlots = append(plan.httpRequestSlots, idx)
+		} else if httpRespType.AssignableTo(argT) {
+			plan.httpResponseSlots = append(plan.httpResponseSlots, idx)
+		}
+		if contextType.AssignableTo(argT) {
+			plan.contextSlots = append(plan.contextSlots, idx)
+		}
+		if argT == lastEventIDType {
+			plan.lastEventIDSlots = append(plan.lastEventIDSlots, idx)
+		}
+		if argT == wsConnType {
+			plan.wsConnSlots = append(plan.wsConnSlots, idx)
+		}
+		if argT.Kind() == reflect.Ptr && argT.Elem().Kind() == reflect.Struct {
+			plan.structSlots = append(plan.structSlots, idx)
+			plan.bindings[idx] = newStructBinding(argT.Elem())
+			plan.sources[idx] = bindingSourceOf(argT.Elem())
+			if plan.sources[idx] == sourcePath {
+				plan.hasPathSource = true
+			}
+		}
+	}
+
+	return plan
+}
+
+// sameArgumentTypes reports whether the plan is made from the argument types.
+func (plan *handlerPlan) sameArgumentTypes(argumentTypes []reflect.Type) bool {
+	if len(plan.argumentTypes) != len(argumentTypes) {
+		return false
+	}
+	for idx, argT := range argumentTypes {
+		if plan.argumentTypes[idx] != argT {
+			return false
+		}
+	}
+
+	return true
+}
+
+// binding returns the field binding map of the idx-th argument.
+// It returns nil if the argument is not a pointer to struct, then valueStringMapper should be used.
+func (plan *handlerPlan) binding(idx int) *structBinding {
+	return plan.bindings[idx]
+}
+
+// newStructBinding returns the binding of the struct type.
+// Fields are found in the same order as valueStringMapper, and the first one wins for each key.
+func newStructBinding(structT reflect.Type) *structBinding {
+	sb := &structBinding{
+		fields:  make(map[string]*fieldBinding),
+		headers: make(map[string]*fieldBinding),
+		cookies: make(map[string]*fieldBinding),
+		files:   make(map[string]*fieldBinding),
+	}
+	sb.collect(structT, nil, nil)
+
+	return sb
+}
+
+// collect finds fields of the struct. parents are struct types embedded by pointers on the way, to stop the recursion.
+func (sb *structBinding) collect(structT reflect.Type, index []int, parents []reflect.Type) {
+	for i, numField := 0, structT.NumField(); i < numField; i++ {
+		sf := structT.Field(i)
+		if NewTagJSON(sf.Tag).Ignored() {
+			continue
+		}
+
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+
+		if sf.Anonymous {
+			embeddedT := sf.Type
+			if embeddedT.Kind() == reflect.Ptr {
+				// embedded pointers are allocated when their fields are set
+				embeddedT = embeddedT.Elem()
+				if embeddedT.Kind() != reflect.Struct || containsType(parents, embeddedT) {
+					continue
+				}
+				parents = append(parents[:len(parents):len(parents)], embeddedT)
+			} else if embeddedT.Kind() != reflect.Struct {
+				// embedded types other than structs have no fields to be bound
+				continue
+			}
+			sb.collect(embeddedT, fieldIndex, parents)
+			continue
+		}
+
+		fields := sb.fields
+		key := structFieldToKey(sf)
+		if sf.Type == fileType || sf.Type == filesType {
+			fields = sb.files
+		}
+		switch source, name := fieldSourceOf(sf); source {
+		case sourceHeader:
+			fields = sb.headers
+			if name != "" {
+				key = name
+			}
+		case sourceCookie:
+			fields = sb.cookies
+			if name != "" {
+				key = name
+			}
+		}
+		if _, ok := fields[key]; ok {
+			continue
+		}
+		fb := &fieldBinding{
+			index:        fieldIndex,
+			stringParser: sf.Type.AssignableTo(stringParserType),
+			isSlice:      sf.Type.Kind() == reflect.Slice,
+		}
+		if fb.isSlice {
+			fb.elemStringParser = sf.Type.Elem().AssignableTo(stringParserType)
+		}
+		fields[key] = fb
+	}
+}
+
+// setString works like valueStringMapper.
+func (sb *structBinding) setString(target reflect.Value, key string, value string) (bool, error) {
+	fb, ok := sb.fields[key]
+	if !ok {
+		return false, nil
+	}
+
+	return true, fb.setString(target, value)
+}
+
+func containsType(types []reflect.Type, t reflect.Type) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// field returns the field of the target. Nil embedded pointers on the way are allocated if alloc is true,
+// otherwise the invalid value is returned. Those of unexported types can not be allocated.
+func (fb *fieldBinding) field(target reflect.Value, alloc bool) reflect.Value {
+	f := target.Elem()
+	for i, x := range fb.index {
+		if i != 0 && f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				if !alloc || !f.CanSet() {
+					return reflect.Value{}
+				}
+				f.Set(reflect.New(f.Type().Elem()))
+			}
+			f = f.Elem()
+		}
+		f = f.Field(x)
+	}
+
+	return f
+}
+
+// zero clears the field.
+func (fb *fieldBinding) zero(target reflect.Value) {
+	f := fb.field(target, false)
+	if f.IsValid() {
+		f.Set(reflect.Zero(f.Type()))
+	}
+}
+
+func (fb *fieldBinding) setString(target reflect.Value, value string) error {
+	f := fb.field(target, true)
+	if !f.IsValid() {
+		return nil
+	}
+	if fb.stringParser {
+		v, err := reflect.New(f.Type()).Interface().(StringParser).ParseString(value)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(v))
+		return nil
+	}
+
+	return SetValueFromString(f, value)
+}
+
+// setFiles sets the first file to *File field, or all files to []*File field.
+func (fb *fieldBinding) setFiles(target reflect.Value, files []*File) {
+	if len(files) == 0 {
+		return
+	}
+
+	f := fb.field(target, true)
+	if !f.IsValid() {
+		return
+	}
+	if fb.isSlice {
+		f.Set(reflect.ValueOf(files))
+		return
+	}
+	f.Set(reflect.ValueOf(files[0]))
+}
+
+// setStrings works like valueStringSliceMapper.
+func (sb *structBinding) setStrings(target reflect.Value, key string, values []string) (bool, error) {
+	fb, ok := sb.fields[key]
+	if !ok {
+		return false, nil
+	}
+
+	if !fb.isSlice && len(values) == 0 {
+		return false, nil
+	}
+
+	err := fb.setStrings(target, values)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (fb *fieldBinding) setStrings(target reflect.Value, values []string) error {
+	if !fb.isSlice {
+		if len(values) == 0 {
+			return nil
+		}
+		return fb.setString(target, values[0])
+	}
+
+	f := fb.field(target, true)
+	if !f.IsValid() {
+		return nil
+	}
+	if fb.elemStringParser {
+		sp := reflect.New(f.Type().Elem()).Interface().(StringParser)
+		for _, value := range values {
+			v, err := sp.ParseString(value)
+			if err != nil {
+				return err
+			}
+			f.Set(reflect.Append(f, reflect.ValueOf(v)))
+		}
+		return nil
+	}
+
+	return SetValueFromStrings(f, values)
+}
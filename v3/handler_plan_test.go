@@ -0,0 +1,176 @@
+package ucon
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type embeddedOfHandlerPlan struct {
+	Text string `json:"text"`
+}
+
+type requestOfHandlerPlan struct {
+	embeddedOfHandlerPlan
+	ID      int      `json:"id"`
+	Offset  int      `json:"offset"`
+	Tags    []string `json:"tags"`
+	Ignored string   `json:"-"`
+}
+
+type requestOfHandlerPlanWithEmbeddedPtr struct {
+	*embeddedOfHandlerPlan
+	ID int `json:"id"`
+}
+
+func TestGetHandlerPlan(t *testing.T) {
+	h := func(c context.Context, w http.ResponseWriter, r *http.Request, req *requestOfHandlerPlan, v interface{}) {}
+
+	plan := getHandlerPlan(reflect.TypeOf(h))
+	if v := getHandlerPlan(reflect.TypeOf(h)); v != plan {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	if v := len(plan.argumentTypes); v != 5 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := fmt.Sprint(plan.httpRequestSlots); v != "[2 4]" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := fmt.Sprint(plan.httpResponseSlots); v != "[1]" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := fmt.Sprint(plan.contextSlots); v != "[0 4]" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := fmt.Sprint(plan.structSlots); v != "[2 3]" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := plan.binding(3); v == nil {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := plan.binding(0); v != nil {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	// fields of embedded pointers are bound too
+	h2 := func(req *requestOfHandlerPlanWithEmbeddedPtr) {}
+	if v := getHandlerPlan(reflect.TypeOf(h2)).binding(0); v == nil || v.fields["text"] == nil {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestStructBinding(t *testing.T) {
+	sb := newStructBinding(reflect.TypeOf(requestOfHandlerPlan{}))
+
+	cases := []struct {
+		key    string
+		values []string
+	}{
+		{"id", []string{"1"}},
+		{"offset", []string{"100", "200"}},
+		{"text", []string{"hi"}},
+		{"tags", []string{"a", "b"}},
+		{"-", []string{"x"}},
+		{"Ignored", []string{"x"}},
+		{"unknown", []string{"x"}},
+	}
+
+	expected := reflect.New(reflect.TypeOf(requestOfHandlerPlan{}))
+	actual := reflect.New(reflect.TypeOf(requestOfHandlerPlan{}))
+	for _, c := range cases {
+		found1, err1 := valueStringSliceMapper(expected, c.key, c.values)
+		found2, err2 := sb.setStrings(actual, c.key, c.values)
+		if found1 != found2 || (err1 == nil) != (err2 == nil) {
+			t.Errorf("unexpected: %s %v %v", c.key, found2, err2)
+		}
+	}
+	if !reflect.DeepEqual(expected.Interface(), actual.Interface()) {
+		t.Errorf("unexpected: %#v", actual.Interface())
+	}
+
+	found, err := sb.setString(actual, "id", "abc")
+	if !found || err == nil {
+		t.Errorf("unexpected: %v %v", found, err)
+	}
+}
+
+func setupHandlerPlanBenchmark() *ServeMux {
+	mux := NewServeMux()
+	mux.Middleware(ResponseMapper())
+	mux.Middleware(HTTPRWDI())
+	mux.Middleware(ContextDI())
+	mux.Middleware(RequestObjectMapper())
+
+	h := func(c context.Context, w http.ResponseWriter, r *http.Request, req *requestOfHandlerPlan) (*ResponseOfRoutingInfoAddHandlers, error) {
+		return &ResponseOfRoutingInfoAddHandlers{Text: req.Text}, nil
+	}
+	mux.HandleFunc("GET,PUT", "/api/todo/{id}", h)
+	mux.Prepare()
+
+	return mux
+}
+
+func BenchmarkServeMuxServeHTTP_query(b *testing.B) {
+	mux := setupHandlerPlanBenchmark()
+	r := httptest.NewRequest("GET", "/api/todo/1?offset=100&text=hi&tags=a&tags=b", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
+
+func BenchmarkServeMuxServeHTTP_jsonBody(b *testing.B) {
+	mux := setupHandlerPlanBenchmark()
+	r := httptest.NewRequest("PUT", "/api/todo/1?offset=100", nil)
+	r.Header.Set("Content-Type", "application/json")
+	body := `{"text":"hi","tags":["a","b"]}`
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Body = ioutil.NopCloser(strings.NewReader(body))
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
+
+func TestBubbleHandlerPlan_withRewrittenArgumentTypes(t *testing.T) {
+	mux := NewServeMux()
+	mux.Middleware(func(b *Bubble) error {
+		if b.R.URL.Query().Get("rewrite") != "" {
+			// ContextDI skips the argument
+			b.ArgumentTypes[0] = reflect.TypeOf("")
+		}
+		return b.Next()
+	})
+	mux.Middleware(ContextDI())
+	mux.Middleware(func(b *Bubble) error {
+		if !b.Arguments[0].IsValid() {
+			b.Arguments[0] = reflect.ValueOf(context.WithValue(b.Context, "key", "custom"))
+		}
+		return b.Next()
+	})
+	var injected []string
+	mux.HandleFunc("GET", "/api/test", func(c context.Context) {
+		injected = append(injected, fmt.Sprint(c.Value("key")))
+	})
+	mux.Prepare()
+
+	for _, path := range []string{"/api/test?rewrite=1", "/api/test"} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+	}
+
+	// the rewrite is only for the bubble
+	if v := strings.Join(injected, ","); v != "custom,<nil>" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
@@ -7,10 +7,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"reflect"
-	"strings"
 
 	"github.com/favclip/golidator"
 )
@@ -63,7 +61,15 @@ func (he *httpError) ErrorMessage() interface{} {
 }
 
 func (he *httpError) Error() string {
-	return fmt.Sprintf("status code %d: %s", he.StatusCode(), he.ErrorMessage())
+	// NOTE ErrorMessage returns itself, so Message is used to avoid the infinite recursion.
+	return fmt.Sprintf("status code %d: %v", he.StatusCode(), he.Message)
+}
+
+func newHTTPError(code int) *httpError {
+	return &httpError{
+		Code:    code,
+		Message: http.StatusText(code),
+	}
 }
 
 func newBadRequestf(format string, a ...interface{}) *httpError {
@@ -81,15 +87,12 @@ type StringParser interface {
 // HTTPRWDI injects Bubble.R and Bubble.W into the bubble.Arguments.
 func HTTPRWDI() MiddlewareFunc {
 	return func(b *Bubble) error {
-		for idx, argT := range b.ArgumentTypes {
-			if httpReqType.AssignableTo(argT) {
-				b.Arguments[idx] = reflect.ValueOf(b.R)
-				continue
-			}
-			if httpRespType.AssignableTo(argT) {
-				b.Arguments[idx] = reflect.ValueOf(b.W)
-				continue
-			}
+		plan := b.handlerPlan()
+		for _, idx := range plan.httpRequestSlots {
+			b.Arguments[idx] = reflect.ValueOf(b.R)
+		}
+		for _, idx := range plan.httpResponseSlots {
+			b.Arguments[idx] = reflect.ValueOf(b.W)
 		}
 
 		return b.Next()
@@ -105,11 +108,8 @@ func NetContextDI() MiddlewareFunc {
 // ContextDI injects Bubble.Context into the bubble.Arguments.
 func ContextDI() MiddlewareFunc {
 	return func(b *Bubble) error {
-		for idx, argT := range b.ArgumentTypes {
-			if contextType.AssignableTo(argT) {
-				b.Arguments[idx] = reflect.ValueOf(b.Context)
-				continue
-			}
+		for _, idx := range b.handlerPlan().contextSlots {
+			b.Arguments[idx] = reflect.ValueOf(b.Context)
 		}
 
 		return b.Next()
@@ -117,93 +117,64 @@ func ContextDI() MiddlewareFunc {
 }
 
 // RequestObjectMapper converts a request to object and injects it into the bubble.Arguments.
+// The first argument struct is filled from path parameters, the query string and the body.
+// Its fields tagged as `swagger:",in=header"` or `swagger:",in=cookie"` are filled only from request headers or cookies.
+// Files of multipart/form-data are filled into fields of *File or []*File.
+// Argument structs which embed a marker like InPath, InQuery, InHeader, InCookie or InBody are filled only from the source.
 func RequestObjectMapper() MiddlewareFunc {
+	return RequestObjectMapperWithOption(nil)
+}
+
+// RequestObjectMapperWithOption returns RequestObjectMapper with limits of the request body.
+func RequestObjectMapperWithOption(opts *RequestObjectMapperOption) MiddlewareFunc {
+	// copy not to modify the option of the caller
+	o := RequestObjectMapperOption{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxMemory == 0 {
+		o.MaxMemory = DefaultMaxMemory
+	}
+	opts = &o
+
 	return func(b *Bubble) error {
+		if opts.MaxBodySize > 0 && b.R.Body != nil {
+			b.R.Body = &limitedBody{ReadCloser: b.R.Body, remaining: opts.MaxBodySize}
+		}
+
+		plan := b.handlerPlan()
 		argIdx := -1
-		var argT reflect.Type
-		for idx, arg := range b.Arguments {
-			if arg.IsValid() {
+		// only support for struct
+		for _, idx := range plan.structSlots {
+			if b.Arguments[idx].IsValid() {
 				// already injected
 				continue
 			}
-			if b.ArgumentTypes[idx].Kind() != reflect.Ptr || b.ArgumentTypes[idx].Elem().Kind() != reflect.Struct {
-				// only support for struct
+			if b.mux.provider(b.ArgumentTypes[idx]) != nil {
+				// injected by the provider later
 				continue
 			}
-			argT = b.ArgumentTypes[idx]
-			argIdx = idx
-			break
-		}
-
-		if argT == nil {
-			return b.Next()
-		}
-
-		reqV := reflect.New(argT.Elem())
-		req := reqV.Interface()
-
-		// NOTE value will be overwritten by below process
-		// url path extract
-		if v := b.Context.Value(PathParameterKey); v != nil {
-			params, ok := v.(map[string]string)
-			if !ok {
-				return ErrInvalidPathParameterType
-			}
-			for key, value := range params {
-				found, _ := valueStringMapper(reqV, key, value)
-				if !found {
-					return ErrPathParameterFieldMissing
+			if source := plan.sources[idx]; source != sourceAll {
+				reqV, err := b.bindObject(idx, source, true, opts)
+				if err != nil {
+					return err
 				}
+				b.Arguments[idx] = reqV
+				continue
 			}
-		}
-
-		// url get parameter
-		for key, ss := range b.R.URL.Query() {
-			_, err := valueStringSliceMapper(reqV, key, ss)
-			if err != nil {
-				return err
+			if argIdx == -1 {
+				argIdx = idx
 			}
 		}
 
-		// request body as JSON
-		{
-			// where is the spec???
-			ct := strings.Split(b.R.Header.Get("Content-Type"), ";")
-			// TODO check charset
-			if ct[0] == "application/json" {
-				var body []byte
-				var err error
-				if b.R.Body != nil {
-					// this case occured in unit test
-					defer b.R.Body.Close()
-					body, err = ioutil.ReadAll(b.R.Body)
-				}
-				if err != nil {
-					return err
-				}
-
-				if len(body) == 2 {
-					// dirty hack. {} map to []interface or [] map to normal struct.
-				} else if len(body) != 0 {
-					err := json.Unmarshal(body, req)
-					if err != nil {
-						return newBadRequestf(err.Error())
-					}
-				}
-
-			} else if ct[0] == "application/x-www-form-urlencoded" {
-				err := b.R.ParseForm()
-				if err != nil {
-					return err
-				}
+		if argIdx == -1 {
+			return b.Next()
+		}
 
-				for key, ss := range b.R.Form {
-					_, err := valueStringSliceMapper(reqV, key, ss)
-					if err != nil {
-						return err
-					}
-				}
-			}
+		// path parameters can be held by the other argument
+		reqV, err := b.bindObject(argIdx, sourceAll, !plan.hasPathSource, opts)
+		if err != nil {
+			return err
 		}
 		// NOTE need request body as a=b&c=d style parsing?
 
@@ -214,10 +185,19 @@ func RequestObjectMapper() MiddlewareFunc {
 }
 
 // ResponseMapper converts a response object to JSON and writes it as response body.
+// The status code is 200, or given by WithStatus, a StatusCode return value or a Response return value.
+// The media type of response body is negotiated by Accept header of the request, see ServeMux.RegisterCodec.
+// It writes ErrNotAcceptable if no codec is acceptable. Error objects are always written as JSON.
+// io.Reader and receivable channels are streamed, see NDJSONMediaType. Channels of Event are sent as Server-Sent Events.
 func ResponseMapper() MiddlewareFunc {
 	return func(b *Bubble) error {
 		err := b.Next()
 
+		// the connection is taken over by WebSocket
+		if b.hijacked {
+			return err
+		}
+
 		// first, error handling
 		if err != nil {
 			return b.writeErrorObject(err)
@@ -232,34 +212,66 @@ func ResponseMapper() MiddlewareFunc {
 			}
 		}
 
+		// status code from the route or handlers
+		status := http.StatusOK
+		statusGiven := false
+		if b.route != nil && b.route.Status != 0 {
+			status = b.route.Status
+			statusGiven = true
+		}
+		for _, rv := range b.Returns {
+			if rv.Type() == statusCodeType && rv.Int() != 0 {
+				status = int(rv.Int())
+				statusGiven = true
+			}
+		}
+
 		// last, write payload
 		for _, rv := range b.Returns {
-			if rv.Type().AssignableTo(errorType) {
+			if rv.Type().AssignableTo(errorType) || rv.Type() == statusCodeType {
 				continue
 			}
 
 			v := rv.Interface()
 			if m, ok := v.(HTTPResponseModifier); ok {
 				return m.Handle(b)
-			} else if !rv.IsNil() {
-				var resp []byte
-				var err error
-				if b.Debug {
-					resp, err = json.MarshalIndent(v, "", "  ")
-				} else {
-					resp, err = json.Marshal(v)
+			}
+
+			if resp, ok := v.(*Response); ok && resp != nil {
+				for key, values := range resp.Header {
+					for _, value := range values {
+						b.W.Header().Add(key, value)
+					}
 				}
-				if err != nil {
-					http.Error(b.W, err.Error(), http.StatusInternalServerError)
-					return err
+				if resp.StatusCode != 0 {
+					status = resp.StatusCode
 				}
-				b.W.Header().Set("Content-Type", "application/json; charset=UTF-8")
-				b.W.WriteHeader(http.StatusOK)
-				b.W.Write(resp)
+				if resp.Body == nil {
+					b.W.WriteHeader(status)
+					return nil
+				}
+				v = resp.Body
+				rv = reflect.ValueOf(v)
+			}
+
+			if !bodyAllowedForStatus(status) {
+				b.W.WriteHeader(status)
 				return nil
-			} else {
-				b.W.Header().Set("Content-Type", "application/json; charset=UTF-8")
-				b.W.WriteHeader(http.StatusOK)
+			}
+			if isEventStream(rv) {
+				return b.writeEvents(rv, status)
+			}
+			if isStream(rv) {
+				return b.writeStream(rv, status)
+			}
+
+			entries := b.mux.encoders(b.R.Header.Get("Accept"))
+			if len(entries) == 0 {
+				return b.writeErrorObject(ErrNotAcceptable)
+			}
+			if _, ok := entries[0].codec.(JSONCodec); ok && isNil(rv) {
+				b.W.Header().Set("Content-Type", entries[0].contentType)
+				b.W.WriteHeader(status)
 				if rv.Type().Kind() == reflect.Slice {
 					b.W.Write([]byte("[]"))
 				} else {
@@ -267,6 +279,31 @@ func ResponseMapper() MiddlewareFunc {
 				}
 				return nil
 			}
+
+			// the next acceptable codec is tried if the value can not be encoded by the codec
+			var err error
+			for _, entry := range entries {
+				var resp []byte
+				if im, ok := entry.codec.(indentMarshaler); ok && b.Debug {
+					resp, err = im.MarshalIndent(v, "", "  ")
+				} else {
+					resp, err = entry.codec.Marshal(v)
+				}
+				if err != nil {
+					continue
+				}
+				b.W.Header().Set("Content-Type", entry.contentType)
+				b.W.WriteHeader(status)
+				b.W.Write(resp)
+				return nil
+			}
+			// nothing is written, the error is handled by ServeMux
+			return err
+		}
+
+		// no payload, e.g. `func(...) (ucon.StatusCode, error)`
+		if statusGiven {
+			b.W.WriteHeader(status)
 		}
 
 		return nil
@@ -274,6 +311,10 @@ func ResponseMapper() MiddlewareFunc {
 }
 
 func (b *Bubble) writeErrorObject(err error) error {
+	return writeErrorObject(b.W, err, b.Debug)
+}
+
+func writeErrorObject(w http.ResponseWriter, err error, debug bool) error {
 	he, ok := err.(HTTPErrorResponse)
 	if !ok {
 		he = &httpError{
@@ -287,18 +328,19 @@ func (b *Bubble) writeErrorObject(err error) error {
 		msgObj = he
 	}
 	var resp []byte
-	if b.Debug {
+	if debug {
 		resp, err = json.MarshalIndent(msgObj, "", "  ")
 	} else {
 		resp, err = json.Marshal(msgObj)
 	}
 	if err != nil {
-		http.Error(b.W, err.Error(), http.StatusInternalServerError)
-		return err
+		// the error object can not be marshaled, write the generic one instead.
+		he = newHTTPError(http.StatusInternalServerError)
+		resp, _ = json.Marshal(he)
 	}
-	b.W.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	b.W.WriteHeader(he.StatusCode())
-	b.W.Write(resp)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(he.StatusCode())
+	w.Write(resp)
 	return nil
 }
 
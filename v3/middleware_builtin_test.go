@@ -439,3 +439,13 @@ func TestCSRFProtect_mismatchCSRFToken(t *testing.T) {
 		t.Fatalf("unexpected: %v", v)
 	}
 }
+
+func TestHTTPErrorError(t *testing.T) {
+	// ErrorMessage returns the httpError itself, Error must not format it recursively.
+	if v := newHTTPError(http.StatusNotFound).Error(); v != "status code 404: Not Found" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := newBadRequestf("invalid %s", "id").Error(); v != "status code 400: invalid id" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
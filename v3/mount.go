@@ -0,0 +1,89 @@
+package ucon
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Mount mounts the http.Handler to the subtree under the prefix.
+// The handler receives requests whose path starts with the prefix and `/`, and the prefix is stripped from the path.
+// Requests of the prefix itself are redirected to the prefix with `/`, like http.ServeMux does.
+// Middlewares of ServeMux are skipped, but plugins which hook requests run. Use MountWithMiddlewares if middlewares are required.
+func (m *ServeMux) Mount(prefix string, h http.Handler, opts ...RouteOption) {
+	m.mount(nil, prefix, h, false, opts)
+}
+
+// MountWithMiddlewares mounts the http.Handler like Mount, but middlewares of ServeMux run before the handler.
+// Middlewares can replace Bubble.W and Bubble.R, and the handler receives those.
+func (m *ServeMux) MountWithMiddlewares(prefix string, h http.Handler, opts ...RouteOption) {
+	m.mount(nil, prefix, h, true, opts)
+}
+
+// Mount mounts the http.Handler to the subtree under the prefix of the group. see ServeMux.Mount.
+func (g *RouteGroup) Mount(prefix string, h http.Handler, opts ...RouteOption) {
+	g.mux.mount(g, joinPath(g.prefix, prefix), h, false, opts)
+}
+
+// MountWithMiddlewares mounts the http.Handler like Mount, but middlewares of ServeMux and RouteGroup run before the handler.
+func (g *RouteGroup) MountWithMiddlewares(prefix string, h http.Handler, opts ...RouteOption) {
+	g.mux.mount(g, joinPath(g.prefix, prefix), h, true, opts)
+}
+
+func (m *ServeMux) mount(g *RouteGroup, prefix string, h http.Handler, withMiddlewares bool, opts []RouteOption) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mc := &mountContainer{
+		handler:         http.StripPrefix(prefix, h),
+		mounted:         h,
+		withMiddlewares: withMiddlewares,
+		Context:         background,
+	}
+	m.handle(g, "*", prefix+"/{path...}", mc, opts)
+
+	if prefix != "" {
+		m.handle(g, "*", prefix, &mountContainer{
+			handler:         http.HandlerFunc(redirectToSubtree),
+			mounted:         h,
+			withMiddlewares: withMiddlewares,
+			Context:         background,
+		}, opts)
+	}
+}
+
+// redirectToSubtree redirects the request of the bare prefix to the subtree.
+func redirectToSubtree(w http.ResponseWriter, r *http.Request) {
+	u := *r.URL
+	u.Path += "/"
+	if u.RawPath != "" {
+		u.RawPath += "/"
+	}
+
+	code := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		// keep the method and the body
+		code = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, u.String(), code)
+}
+
+// mountContainer is a HandlerContainer of the mounted http.Handler.
+type mountContainer struct {
+	handler         http.Handler
+	mounted         http.Handler
+	withMiddlewares bool
+	Context
+}
+
+func (mc *mountContainer) Handler() interface{} {
+	return mc.handler.ServeHTTP
+}
+
+func (mc *mountContainer) invoke(b *Bubble) error {
+	// middlewares may replace W and R.
+	mc.handler.ServeHTTP(b.W, b.R)
+	return nil
+}
+
+// Mount mounts the http.Handler to the subtree under the prefix of DefaultMux.
+func Mount(prefix string, h http.Handler, opts ...RouteOption) {
+	DefaultMux.Mount(prefix, h, opts...)
+}
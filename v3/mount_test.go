@@ -0,0 +1,107 @@
+package ucon
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServeMuxMount(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	var called []string
+	Middleware(func(b *Bubble) error {
+		called = append(called, "mux")
+		return b.Next()
+	})
+	Orthodox()
+
+	HandleFunc("GET", "/", func() (*ResponseOfRoutingInfoAddHandlers, error) {
+		return &ResponseOfRoutingInfoAddHandlers{Text: "root"}, nil
+	})
+	HandleFunc("GET", "/metrics/health", func() (*ResponseOfRoutingInfoAddHandlers, error) {
+		return &ResponseOfRoutingInfoAddHandlers{Text: "health"}, nil
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.Write([]byte(r.URL.Path))
+	})
+	Mount("/debug/pprof/", h)
+	DefaultMux.MountWithMiddlewares("/metrics", h)
+	Group("/admin").Mount("/oauth", h)
+
+	DefaultMux.Prepare()
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		called string
+	}{
+		{"GET", "/debug/pprof/heap", "/heap", ""},
+		{"POST", "/debug/pprof/profile/cpu", "/profile/cpu", ""},
+		{"PROPFIND", "/debug/pprof/", "/", ""},
+		{"GET", "/metrics/", "/", "mux"},
+		{"PUT", "/metrics/a/b", "/a/b", "mux"},
+		{"GET", "/admin/oauth/token", "/token", ""},
+		// the route in the subtree is stronger than the mount
+		{"GET", "/metrics/health", `{"text":"health"}`, "mux"},
+		// redirected to the subtree
+		{"GET", "/debug/pprof", "/", ""},
+		{"POST", "/metrics", "/", "mux,mux"},
+	}
+	for _, c := range cases {
+		called = nil
+
+		resp := MakeHandlerTestBed(t, c.method, c.path, nil)
+		if v := resp.StatusCode; v != http.StatusOK {
+			t.Errorf("unexpected: %v %s", v, c.path)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := string(body); v != c.body {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := strings.Join(called, ","); v != c.called {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+}
+
+func TestRouteDefinitionMounted(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	HandleFunc("GET", "/api/test", func() {})
+	Mount("/debug", http.NotFoundHandler())
+
+	for _, rd := range DefaultMux.router.handlers {
+		if v := rd.Mounted(); v != (rd.PathTemplate.PathTemplate != "/api/test") {
+			t.Errorf("unexpected: %v %s", v, rd.PathTemplate.PathTemplate)
+		}
+	}
+}
+
+func TestServeMuxMount_withPlugin(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	plugin := &TargetOfLifecyclePlugin{}
+	Plugin(plugin)
+	Mount("/debug", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plugin.called = append(plugin.called, "handler")
+	}))
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "GET", "/debug/vars", nil)
+	if v := resp.StatusCode; v != http.StatusOK {
+		t.Errorf("unexpected: %v", v)
+	}
+	// middlewares of the plugin are skipped too
+	if v := strings.Join(plugin.called, ","); v != "start,handler,end" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
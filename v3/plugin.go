@@ -1,6 +1,10 @@
 package ucon
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
 
 type pluginContainer struct {
 	base interface{}
@@ -11,14 +15,70 @@ type HandlersScannerPlugin interface {
 	HandlersScannerProcess(m *ServeMux, rds []*RouteDefinition) error
 }
 
+// RouteRegisteredPlugin is an interface to make a plugin for hooking the registration of routes.
+// It is called for each route definition, and for routes registered before the plugin is added too.
+// If it returns an error, the registration panics.
+type RouteRegisteredPlugin interface {
+	RouteRegisteredProcess(m *ServeMux, rd *RouteDefinition) error
+}
+
+// MiddlewarePlugin is an interface to make a plugin which contributes middlewares.
+// The middlewares are appended to ServeMux when the plugin is added.
+type MiddlewarePlugin interface {
+	Middlewares(m *ServeMux) []MiddlewareFunc
+}
+
+// RequestStartPlugin is an interface to make a plugin for hooking the start of requests.
+// It is called before the middleware chain, and it can replace Bubble.Context and Bubble.W.
+// If it returns an error, the request is handled as an error without the chain.
+type RequestStartPlugin interface {
+	RequestStartProcess(b *Bubble) error
+}
+
+// RequestEndPlugin is an interface to make a plugin for hooking the end of requests.
+// It is called after the middleware chain, even if the chain returns an error.
+type RequestEndPlugin interface {
+	RequestEndProcess(b *Bubble)
+}
+
+// RequestErrorPlugin is an interface to make a plugin for hooking errors of requests.
+// It is called with errors which escape from the middleware chain, before ServeMux.ErrorHandler.
+type RequestErrorPlugin interface {
+	RequestErrorProcess(b *Bubble, err error)
+}
+
+// ServerStartPlugin is an interface to make a plugin for hooking the start of the server.
+// It is called before the server starts accepting requests, and the server stops starting if it returns an error.
+type ServerStartPlugin interface {
+	ServerStartProcess(m *ServeMux, s *http.Server) error
+}
+
+// ServerShutdownPlugin is an interface to make a plugin for hooking the shutdown of the server.
+// It is called after in-flight requests are drained, to flush the state of the plugin.
+type ServerShutdownPlugin interface {
+	ServerShutdownProcess(c context.Context, m *ServeMux) error
+}
+
 func (p *pluginContainer) check() {
-	if p.HandlersScanner() != nil {
+	if p.used() {
 		return
 	}
 
 	panic(fmt.Sprintf("unused plugin: %#v", p.base))
 }
 
+// used reports whether the plugin implements one of plugin interfaces.
+func (p *pluginContainer) used() bool {
+	switch p.base.(type) {
+	case HandlersScannerPlugin, RouteRegisteredPlugin, MiddlewarePlugin,
+		RequestStartPlugin, RequestEndPlugin, RequestErrorPlugin,
+		ServerStartPlugin, ServerShutdownPlugin:
+		return true
+	}
+
+	return false
+}
+
 // HandlersScanner returns itself if it implements HandlersScannerPlugin.
 func (p *pluginContainer) HandlersScanner() HandlersScannerPlugin {
 	if v, ok := p.base.(HandlersScannerPlugin); ok {
@@ -28,6 +88,69 @@ func (p *pluginContainer) HandlersScanner() HandlersScannerPlugin {
 	return nil
 }
 
+// RouteRegistered returns itself if it implements RouteRegisteredPlugin.
+func (p *pluginContainer) RouteRegistered() RouteRegisteredPlugin {
+	if v, ok := p.base.(RouteRegisteredPlugin); ok {
+		return v
+	}
+
+	return nil
+}
+
+// Middleware returns itself if it implements MiddlewarePlugin.
+func (p *pluginContainer) Middleware() MiddlewarePlugin {
+	if v, ok := p.base.(MiddlewarePlugin); ok {
+		return v
+	}
+
+	return nil
+}
+
+// RequestStart returns itself if it implements RequestStartPlugin.
+func (p *pluginContainer) RequestStart() RequestStartPlugin {
+	if v, ok := p.base.(RequestStartPlugin); ok {
+		return v
+	}
+
+	return nil
+}
+
+// RequestEnd returns itself if it implements RequestEndPlugin.
+func (p *pluginContainer) RequestEnd() RequestEndPlugin {
+	if v, ok := p.base.(RequestEndPlugin); ok {
+		return v
+	}
+
+	return nil
+}
+
+// RequestError returns itself if it implements RequestErrorPlugin.
+func (p *pluginContainer) RequestError() RequestErrorPlugin {
+	if v, ok := p.base.(RequestErrorPlugin); ok {
+		return v
+	}
+
+	return nil
+}
+
+// ServerStart returns itself if it implements ServerStartPlugin.
+func (p *pluginContainer) ServerStart() ServerStartPlugin {
+	if v, ok := p.base.(ServerStartPlugin); ok {
+		return v
+	}
+
+	return nil
+}
+
+// ServerShutdown returns itself if it implements ServerShutdownPlugin.
+func (p *pluginContainer) ServerShutdown() ServerShutdownPlugin {
+	if v, ok := p.base.(ServerShutdownPlugin); ok {
+		return v
+	}
+
+	return nil
+}
+
 type emptyCtx int
 
 var background = new(emptyCtx)
@@ -0,0 +1,107 @@
+package ucon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type TargetOfLifecyclePlugin struct {
+	called []string
+	routes []string
+}
+
+func (p *TargetOfLifecyclePlugin) RouteRegisteredProcess(m *ServeMux, rd *RouteDefinition) error {
+	p.routes = append(p.routes, rd.Method+" "+rd.PathTemplate.PathTemplate)
+	return nil
+}
+
+func (p *TargetOfLifecyclePlugin) Middlewares(m *ServeMux) []MiddlewareFunc {
+	return []MiddlewareFunc{
+		func(b *Bubble) error {
+			p.called = append(p.called, "middleware")
+			return b.Next()
+		},
+	}
+}
+
+func (p *TargetOfLifecyclePlugin) RequestStartProcess(b *Bubble) error {
+	p.called = append(p.called, "start")
+	b.Context = context.WithValue(b.Context, "plugin", "value")
+	if b.R.URL.Query().Get("reject") != "" {
+		return newHTTPError(http.StatusForbidden)
+	}
+	return nil
+}
+
+func (p *TargetOfLifecyclePlugin) RequestEndProcess(b *Bubble) {
+	p.called = append(p.called, "end")
+}
+
+func (p *TargetOfLifecyclePlugin) RequestErrorProcess(b *Bubble, err error) {
+	p.called = append(p.called, "error:"+err.Error())
+}
+
+func TestPluginWithLifecycleHooks(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	HandleFunc("GET", "/api/before", func() {})
+
+	plugin := &TargetOfLifecyclePlugin{}
+	Plugin(plugin)
+	// errors of middlewares before ResponseMapper escape from the chain
+	Middleware(func(b *Bubble) error {
+		if b.R.URL.Path == "/api/error" {
+			return errors.New("failed")
+		}
+		return b.Next()
+	})
+	Orthodox()
+
+	HandleFunc("GET,PUT", "/api/test", func(c context.Context) error {
+		plugin.called = append(plugin.called, "handler:"+c.Value("plugin").(string))
+		return nil
+	})
+	HandleFunc("GET", "/api/error", func() {})
+
+	if v := strings.Join(plugin.routes, ","); v != "GET /api/before,GET /api/test,PUT /api/test,GET /api/error" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	DefaultMux.Prepare()
+
+	cases := []struct {
+		path   string
+		status int
+		called string
+	}{
+		{"/api/test", http.StatusOK, "start,middleware,handler:value,end"},
+		{"/api/test?reject=1", http.StatusForbidden, "start,error:status code 403: Forbidden,end"},
+		{"/api/error", http.StatusInternalServerError, "start,middleware,error:failed,end"},
+		{"/api/unknown", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		plugin.called = nil
+
+		resp := MakeHandlerTestBed(t, "GET", c.path, nil)
+		if v := resp.StatusCode; v != c.status {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := strings.Join(plugin.called, ","); v != c.called {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+}
+
+func TestPluginWithUnusedPlugin(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	defer func() {
+		if err := recover(); err == nil {
+			t.Errorf("unexpected: %v", err)
+		}
+	}()
+	Plugin(&struct{}{})
+}
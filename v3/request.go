@@ -35,6 +35,12 @@ type Bubble struct {
 
 	queueIndex int
 	mux        *ServeMux
+	route      *RouteDefinition
+	plan       *handlerPlan
+	provided   map[reflect.Type]reflect.Value
+	body       []byte
+	// hijacked is true when the connection is taken over by WebSocket, and the response can not be written.
+	hijacked bool
 }
 
 func (b *Bubble) checkHandlerType() error {
@@ -62,32 +68,50 @@ func (b *Bubble) handler() interface{} {
 }
 
 func (b *Bubble) init(m *ServeMux) error {
+	b.mux = m
+	b.Debug = m.Debug
+
 	err := b.checkHandlerType()
 	if err != nil {
 		return err
 	}
 
-	hv := reflect.ValueOf(b.handler())
-	numIn := hv.Type().NumIn()
-	b.ArgumentTypes = make([]reflect.Type, numIn)
-	for i := 0; i < numIn; i++ {
-		b.ArgumentTypes[i] = hv.Type().In(i)
+	if b.route != nil && b.route.plan != nil && b.route.HandlerContainer == b.RequestHandler {
+		b.plan = b.route.plan
+	} else {
+		b.plan = getHandlerPlan(reflect.TypeOf(b.handler()))
+	}
+	// ArgumentTypes is copied from the plan shared between requests, middlewares can rewrite it for the bubble.
+	b.ArgumentTypes = append([]reflect.Type(nil), b.plan.argumentTypes...)
+	b.Arguments = make([]reflect.Value, len(b.ArgumentTypes))
+
+	if _, ok := b.RequestHandler.(*mountContainer); ok {
+		// the mounted handler takes the http.ResponseWriter and the *http.Request, nothing to be injected.
+		b.Arguments[0] = reflect.ValueOf(b.W)
+		b.Arguments[1] = reflect.ValueOf(b.R)
 	}
-	b.Arguments = make([]reflect.Value, numIn)
-
-	b.mux = m
-	b.Debug = m.Debug
 
 	return nil
 }
 
+// handlerPlan returns the plan of RequestHandler.
+// If a middleware has rewritten Bubble.ArgumentTypes, the plan is remade from it for the bubble.
+func (b *Bubble) handlerPlan() *handlerPlan {
+	if b.plan == nil {
+		b.plan = getHandlerPlan(reflect.TypeOf(b.handler()))
+	}
+	if !b.plan.sameArgumentTypes(b.ArgumentTypes) {
+		b.plan = newArgumentsPlan(append([]reflect.Type(nil), b.ArgumentTypes...))
+	}
+	return b.plan
+}
+
 // Next passes the bubble to next middleware.
+// Middlewares of ServeMux run first, then middlewares of RouteGroup, and then middlewares of the RequestHandler.
 // If the bubble reaches at last, RequestHandler will be called.
 func (b *Bubble) Next() error {
-	if b.queueIndex < len(b.mux.middlewares) {
-		qi := b.queueIndex
+	if m := b.middleware(b.queueIndex); m != nil {
 		b.queueIndex++
-		m := b.mux.middlewares[qi]
 		err := m(b)
 		return err
 	}
@@ -95,7 +119,70 @@ func (b *Bubble) Next() error {
 	return b.do()
 }
 
+func (b *Bubble) middleware(idx int) MiddlewareFunc {
+	if mc, ok := b.RequestHandler.(*mountContainer); ok && !mc.withMiddlewares {
+		return nil
+	}
+
+	if idx < len(b.mux.middlewares) {
+		return b.mux.middlewares[idx]
+	}
+	idx -= len(b.mux.middlewares)
+
+	if b.route != nil && b.route.group != nil {
+		var m MiddlewareFunc
+		m, idx = b.route.group.middleware(idx)
+		if m != nil {
+			return m
+		}
+	}
+
+	if mc, ok := b.RequestHandler.(MiddlewareContainer); ok {
+		if mws := mc.Middlewares(); idx < len(mws) {
+			return mws[idx]
+		}
+	}
+
+	return nil
+}
+
+// handlerInvoker is an optional interface of HandlerContainer to call its handler without reflection.
+// invoke must set Bubble.Returns as the handler returns.
+type handlerInvoker interface {
+	invoke(b *Bubble) error
+}
+
 func (b *Bubble) do() error {
+	err := b.resolveArguments()
+	if err != nil {
+		return err
+	}
+
+	ws, err := b.upgradeWebSocket()
+	if err != nil {
+		return err
+	}
+
+	err = b.call()
+	if ws != nil {
+		// the response is already sent by the upgrade, so the error is sent by the close frame.
+		ws.finish(b, err)
+		return nil
+	}
+
+	return err
+}
+
+func (b *Bubble) call() error {
+	if hi, ok := b.RequestHandler.(handlerInvoker); ok {
+		err := hi.invoke(b)
+		if err != nil {
+			return err
+		}
+		b.Handled = true
+		return nil
+	}
+
 	hv := reflect.ValueOf(b.handler())
 
 	if len(b.Arguments) != len(b.ArgumentTypes) || len(b.Arguments) != hv.Type().NumIn() {
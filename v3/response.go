@@ -0,0 +1,51 @@
+package ucon
+
+import (
+	"net/http"
+	"reflect"
+)
+
+// StatusCode is a return value of handlers to change the status code of the response.
+// e.g. `func(req *Req) (*Resp, ucon.StatusCode, error)`
+type StatusCode int
+
+var statusCodeType = reflect.TypeOf(StatusCode(0))
+
+// Response is a return value of handlers to write the status code and headers with the body.
+// The body is converted by ResponseMapper like other return values, and nothing is written if it is nil.
+type Response struct {
+	// StatusCode is the status code of the response. 200 or the status given by WithStatus is used if it is zero.
+	StatusCode int
+	Header     http.Header
+	Body       interface{}
+}
+
+// NewResponse returns a Response of the status code and the body.
+func NewResponse(statusCode int, body interface{}) *Response {
+	return &Response{
+		StatusCode: statusCode,
+		Header:     make(http.Header),
+		Body:       body,
+	}
+}
+
+// bodyAllowedForStatus reports whether the status permits a body. see RFC 7230, section 3.3.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent:
+		return false
+	case status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
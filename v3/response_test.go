@@ -0,0 +1,148 @@
+package ucon
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type TargetOfResponse struct {
+	ID int `json:"id"`
+}
+
+func TestResponseMapper_withResponse(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("POST", "/api/todo", func() (*Response, error) {
+		resp := NewResponse(http.StatusCreated, &TargetOfResponse{ID: 1})
+		resp.Header.Set("Location", "/api/todo/1")
+		return resp, nil
+	})
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "POST", "/api/todo", nil)
+	if v := resp.StatusCode; v != http.StatusCreated {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := resp.Header.Get("Location"); v != "/api/todo/1" {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(body); v != `{"id":1}` {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestResponseMapper_withResponseNoBody(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("DELETE", "/api/todo/{id}", func(req *TargetOfResponse) (*Response, error) {
+		return &Response{StatusCode: http.StatusNoContent}, nil
+	})
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "DELETE", "/api/todo/1", nil)
+	if v := resp.StatusCode; v != http.StatusNoContent {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(body); v != "" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestResponseMapper_withStatusCode(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("POST", "/api/todo", func() (*TargetOfResponse, StatusCode, error) {
+		return &TargetOfResponse{ID: 1}, http.StatusAccepted, nil
+	})
+	HandleFunc("DELETE", "/api/todo/{id}", func(req *TargetOfResponse) (StatusCode, error) {
+		return http.StatusNoContent, nil
+	})
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "POST", "/api/todo", nil)
+	if v := resp.StatusCode; v != http.StatusAccepted {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(body); v != `{"id":1}` {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	resp = MakeHandlerTestBed(t, "DELETE", "/api/todo/1", nil)
+	if v := resp.StatusCode; v != http.StatusNoContent {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestResponseMapper_withStatusOption(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("POST", "/api/todo", func() (*TargetOfResponse, error) {
+		return &TargetOfResponse{ID: 1}, nil
+	}, WithStatus(http.StatusCreated))
+	HandleFunc("DELETE", "/api/todo/{id}", func(req *TargetOfResponse) (*TargetOfResponse, error) {
+		return req, nil
+	}, WithStatus(http.StatusNoContent))
+	HandleFunc("PUT", "/api/todo/{id}", func(req *TargetOfResponse) (*TargetOfResponse, StatusCode, error) {
+		// a return value wins
+		return req, http.StatusOK, nil
+	}, WithStatus(http.StatusCreated))
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "POST", "/api/todo", nil)
+	if v := resp.StatusCode; v != http.StatusCreated {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(body); v != `{"id":1}` {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	resp = MakeHandlerTestBed(t, "DELETE", "/api/todo/1", nil)
+	if v := resp.StatusCode; v != http.StatusNoContent {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(body); v != "" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	resp = MakeHandlerTestBed(t, "PUT", "/api/todo/1", nil)
+	if v := resp.StatusCode; v != http.StatusOK {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := strings.TrimSpace(string(body)); v != `{"id":1}` {
+		t.Errorf("unexpected: %v", v)
+	}
+}
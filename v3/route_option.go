@@ -0,0 +1,43 @@
+package ucon
+
+// RouteOption configures the RouteDefinition on registration.
+type RouteOption func(rd *RouteDefinition)
+
+// WithName gives the name to the route for building its URL by ServeMux.URL.
+// The name must be unique in the ServeMux.
+func WithName(name string) RouteOption {
+	return func(rd *RouteDefinition) {
+		rd.Name = name
+	}
+}
+
+// WithStatus declares the status code of successful responses of the route, e.g. http.StatusCreated.
+// ResponseMapper writes it instead of 200, and the swagger plugin documents the response by it.
+func WithStatus(code int) RouteOption {
+	return func(rd *RouteDefinition) {
+		rd.Status = code
+	}
+}
+
+// WithMatcher appends the match condition to the route.
+// The route is picked only if all of the conditions match to the request.
+func WithMatcher(matcher RouteMatcher) RouteOption {
+	return func(rd *RouteDefinition) {
+		rd.Matchers = append(rd.Matchers, matcher)
+	}
+}
+
+// WithHost appends the condition of the request host to the route. see NewHostMatcher.
+func WithHost(pattern string) RouteOption {
+	return WithMatcher(NewHostMatcher(pattern))
+}
+
+// WithHeader appends the condition of the request header to the route. see NewHeaderMatcher.
+func WithHeader(name string, value string) RouteOption {
+	return WithMatcher(NewHeaderMatcher(name, value))
+}
+
+// WithHeaderRegexp appends the condition of the request header to the route. see NewHeaderRegexpMatcher.
+func WithHeaderRegexp(name string, pattern string) RouteOption {
+	return WithMatcher(NewHeaderRegexpMatcher(name, pattern))
+}
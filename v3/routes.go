@@ -0,0 +1,124 @@
+package ucon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// RouteInfo is a read-only description of the registered route.
+type RouteInfo struct {
+	Method        string   `json:"method"`
+	Path          string   `json:"path"`
+	Name          string   `json:"name,omitempty"`
+	Handler       string   `json:"handler"`
+	File          string   `json:"file,omitempty"`
+	Line          int      `json:"line,omitempty"`
+	ArgumentTypes []string `json:"argumentTypes"`
+	ReturnTypes   []string `json:"returnTypes"`
+	Mounted       bool     `json:"mounted,omitempty"`
+}
+
+// Routes returns descriptions of the registered routes in order of registration.
+func (m *ServeMux) Routes() []*RouteInfo {
+	routes := make([]*RouteInfo, 0, len(m.router.handlers))
+	for _, rd := range m.router.handlers {
+		routes = append(routes, newRouteInfo(rd))
+	}
+
+	return routes
+}
+
+func newRouteInfo(rd *RouteDefinition) *RouteInfo {
+	info := &RouteInfo{
+		Method:        rd.Method,
+		Path:          rd.PathTemplate.PathTemplate,
+		Name:          rd.Name,
+		ArgumentTypes: []string{},
+		ReturnTypes:   []string{},
+		Mounted:       rd.Mounted(),
+	}
+
+	var h interface{} = rd.HandlerContainer.Handler()
+	if mc, ok := rd.HandlerContainer.(*mountContainer); ok {
+		// describe the mounted handler, not the wrapper.
+		h = mc.mounted
+		if hf, ok := h.(http.HandlerFunc); ok {
+			h = (func(http.ResponseWriter, *http.Request))(hf)
+		}
+	}
+
+	hv := reflect.ValueOf(h)
+	if hv.Kind() != reflect.Func {
+		info.Handler = fmt.Sprintf("%T", h)
+		return info
+	}
+
+	if f := runtime.FuncForPC(hv.Pointer()); f != nil {
+		info.Handler = f.Name()
+		info.File, info.Line = f.FileLine(f.Entry())
+	}
+	if rd.Mounted() {
+		return info
+	}
+
+	hT := hv.Type()
+	for i := 0; i < hT.NumIn(); i++ {
+		info.ArgumentTypes = append(info.ArgumentTypes, hT.In(i).String())
+	}
+	for i := 0; i < hT.NumOut(); i++ {
+		info.ReturnTypes = append(info.ReturnTypes, hT.Out(i).String())
+	}
+
+	return info
+}
+
+// RoutesHandler returns a http.Handler which serves the route table by Routes.
+// It serves JSON by default, and a text table if the `format=text` query parameter is given or the request accepts text/plain.
+// It is not registered automatically, e.g. `mux.HandleFunc("GET", "/debug/routes", mux.RoutesHandler().ServeHTTP)`.
+func (m *ServeMux) RoutesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes := m.Routes()
+
+		format := r.URL.Query().Get("format")
+		if format == "" && strings.HasPrefix(r.Header.Get("Accept"), "text/plain") {
+			format = "text"
+		}
+
+		if format == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusOK)
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "METHOD\tPATH\tNAME\tHANDLER\tARGUMENTS\tRETURNS\tLOCATION")
+			for _, route := range routes {
+				location := ""
+				if route.File != "" {
+					location = fmt.Sprintf("%s:%d", route.File, route.Line)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t(%s)\t(%s)\t%s\n",
+					route.Method, route.Path, route.Name, route.Handler,
+					strings.Join(route.ArgumentTypes, ", "), strings.Join(route.ReturnTypes, ", "), location)
+			}
+			tw.Flush()
+			return
+		}
+
+		resp, err := json.Marshal(routes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	})
+}
+
+// Routes returns descriptions of the routes registered to DefaultMux.
+func Routes() []*RouteInfo {
+	return DefaultMux.Routes()
+}
@@ -0,0 +1,120 @@
+package ucon
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func handlerOfRoutesTest(c context.Context, req *RequestOfRoutingInfoAddHandlers) (*ResponseOfRoutingInfoAddHandlers, error) {
+	return nil, nil
+}
+
+func TestServeMuxRoutes(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	HandleFunc("GET", "/api/todo/{id:int}", handlerOfRoutesTest, WithName("todo"))
+	HandleFunc("*", "/", func() {})
+	Mount("/debug", http.NotFoundHandler())
+
+	routes := Routes()
+	// the mount has the subtree and the redirect of the prefix
+	if v := len(routes); v != 4 {
+		t.Fatalf("unexpected: %v", v)
+	}
+
+	route := routes[0]
+	if v := route.Method; v != "GET" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := route.Path; v != "/api/todo/{id:int}" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := route.Name; v != "todo" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := route.Handler; v != "github.com/favclip/ucon/v3.handlerOfRoutesTest" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := route.File; !strings.HasSuffix(v, "routes_test.go") {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := route.Line; v == 0 {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := strings.Join(route.ArgumentTypes, ","); v != "context.Context,*ucon.RequestOfRoutingInfoAddHandlers" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := strings.Join(route.ReturnTypes, ","); v != "*ucon.ResponseOfRoutingInfoAddHandlers,error" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	if v := routes[1].Method; v != "*" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	route = routes[2]
+	if v := route.Mounted; !v {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := route.Path; v != "/debug/{path...}" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := route.Method; v != "*" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := route.Handler; v != "net/http.NotFound" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := routes[3].Path; v != "/debug" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestServeMuxRoutesHandler(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("GET", "/api/todo/{id}", handlerOfRoutesTest)
+	HandleFunc("GET", "/debug/routes", DefaultMux.RoutesHandler().ServeHTTP)
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "GET", "/debug/routes", nil)
+	if v := resp.Header.Get("Content-Type"); v != "application/json; charset=UTF-8" {
+		t.Errorf("unexpected: %v", v)
+	}
+	var routes []*RouteInfo
+	err := json.NewDecoder(resp.Body).Decode(&routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := len(routes); v != 2 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := routes[0].Path; v != "/api/todo/{id}" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	resp = MakeHandlerTestBed(t, "GET", "/debug/routes?format=text", nil)
+	if v := resp.Header.Get("Content-Type"); v != "text/plain; charset=UTF-8" {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if v := len(lines); v != 3 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := lines[0]; !strings.HasPrefix(v, "METHOD") {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := lines[1]; !strings.Contains(v, "/api/todo/{id}") || !strings.Contains(v, "(context.Context, *ucon.RequestOfRoutingInfoAddHandlers)") {
+		t.Errorf("unexpected: %v", v)
+	}
+}
@@ -2,12 +2,19 @@ package ucon
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
+	"sync/atomic"
 )
 
+// ErrInvalidHandlerPicked is the error that the picked route definition does not match to the request path.
+var ErrInvalidHandlerPicked = errors.New("[ucon] invalid handler picked")
+
 type methodMatchRate int
 
 const (
@@ -29,27 +36,67 @@ const (
 // 1. Methods must match.
 //    a. If the method of request is `HEAD`, exceptionally `GET` definition is also allowed.
 //    b. If the method of definition is `*`, the definition matches on all method.
+//    c. Handlers mounted by ServeMux.Mount match on all method as strong as the exact match.
 // 2. Paths must match as longer as possible.
 //    a. The path of definition must match to the request path completely.
 //    b. Select the longest match.
 //      * Against Request[/api/foo/hi/comments/1], Definition[/api/foo/{bar}/] is stronger than Definition[/api/foo/].
-// 3. If there are multiple options after 1 and 2 rules, select the earliest one which have been added to router.
+//    c. A catch-all parameter like `{path...}` matches the rest of the path, but it's weaker than exact match.
+//    d. If ServeMux.StrictPathMatch is true, definitions without a catch-all parameter never match longer paths.
+// 3. All of match conditions (RouteDefinition.Matchers) must match.
+//    a. If there are multiple options after 1 and 2 rules, the definition which has more conditions is stronger.
+//      * Against Request[Host: admin.example.com], Definition[WithHost("admin.example.com")] is stronger than Definition[].
+// 4. If there are multiple options after 1, 2 and 3 rules, select the earliest one which have been added to router.
+//
+// The route definitions are compiled into a tree of path segments at ServeMux.Prepare,
+// so the router does not scan all definitions for each request.
+//
+// If no definition matches but some definitions match on the path, the router responds 405 Method Not Allowed with the Allow header.
+// When ServeMux.AutoOptions is true, OPTIONS requests on such paths are answered automatically with the Allow header.
 //
 type Router struct {
 	mux      *ServeMux
 	handlers []*RouteDefinition
+	tree     atomic.Value // *routeTree
 }
 
 func (ro *Router) addRoute(rd *RouteDefinition) {
 	ro.handlers = append(ro.handlers, rd)
 }
 
+// prepare compiles the route definitions into a tree.
+func (ro *Router) prepare() {
+	ro.routeTree()
+}
+
+// routeTree returns the compiled tree. It is rebuilt if routes have been added after the compilation.
+func (ro *Router) routeTree() *routeTree {
+	t, _ := ro.tree.Load().(*routeTree)
+	if t == nil || t.size != len(ro.handlers) {
+		t = newRouteTree(ro.handlers)
+		ro.tree.Store(t)
+	}
+
+	return t
+}
+
 // ServeHTTP routes a request to the handler and creates new bubble.
 func (ro *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rd := ro.pickupBestRouteDefinition(r)
 
 	if rd == nil {
-		http.NotFound(w, r)
+		allowed := ro.allowedMethods(r)
+		if len(allowed) == 0 {
+			ro.mux.handleNotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		if r.Method == "OPTIONS" && ro.mux.AutoOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		ro.mux.handleMethodNotAllowed(w, r)
 		return
 	}
 
@@ -57,21 +104,17 @@ func (ro *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	match, params := rd.PathTemplate.Match(encodedPathFromRequest(r))
 	if !match {
-		http.Error(w, "[ucon] invalid handler picked", http.StatusInternalServerError)
+		ro.mux.handleError(w, r, ErrInvalidHandlerPicked)
 		return
 	}
 	ctx = context.WithValue(ctx, PathParameterKey, params)
 
 	b, err := ro.mux.newBubble(ctx, w, r, rd)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	err = b.Next()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		ro.mux.handleBubbleError(b, err)
 		return
 	}
+	ro.mux.serveBubble(b)
 }
 
 func (ro *Router) pickupBestRouteDefinition(r *http.Request) *RouteDefinition {
@@ -85,7 +128,9 @@ func (ro *Router) pickupBestRouteDefinition(r *http.Request) *RouteDefinition {
 	//   a. Handler側のパスは全長一致しなければならない
 	//   b. より長いパス長のものを優先する /api/foo/bar なら3節 という数え方
 	//      /api/foo/ と /api/foo/{bar}/ というHandlerがあったら、 /api/foo/hi/comments/1 は /api/foo/{bar}/ に割り当てられる
-	// 3. 1,2での評価が最も高いものが複数ある場合は、より早くServeMuxに追加されたHandlerを選ぶ
+	// 3. Matchersがすべて一致する
+	//   a. 1,2での評価が同じ場合、Matchersの数が多いものを優先する
+	// 4. 1,2,3での評価が最も高いものが複数ある場合は、より早くServeMuxに追加されたHandlerを選ぶ
 	//
 	// ルーティングのサンプル
 	// Handler: OPTIONS / , POST /api/todo & Request: OPTIONS /api/todo -> OPTIONS / が選択される
@@ -94,87 +139,111 @@ func (ro *Router) pickupBestRouteDefinition(r *http.Request) *RouteDefinition {
 	var bestRoute *RouteDefinition
 	var bestMethodMatchRate methodMatchRate
 	var bestPathMatchRate int
+	var bestConditionMatchRate int
 
-	methodMatchRate := func(rd *RouteDefinition) methodMatchRate {
-		if rd.Method == "*" {
-			return starMethodMatch
+	// route definitions which match on the path are found by the tree, in order of registration.
+	for _, match := range ro.lookup(r) {
+		rd := match.entry.rd
+		mRate := ro.methodMatchRate(rd, r)
+		if mRate == noMethodMatch {
+			continue
+		} else if mRate < bestMethodMatchRate {
+			continue
 		}
 
-		if rd.Method == "GET" && r.Method == "HEAD" {
-			return overloadMethodMatch
+		pRate := match.rate
+		if pRate == noPathMatch {
+			continue
+		} else if pRate < bestPathMatchRate {
+			continue
 		}
 
-		if rd.Method == r.Method {
-			return exactMethodMatch
+		if !rd.matchConditions(r) {
+			continue
+		}
+		cRate := len(rd.Matchers)
+		if bestMethodMatchRate == mRate && bestPathMatchRate == pRate && cRate <= bestConditionMatchRate {
+			continue
 		}
 
-		return noMethodMatch
+		bestMethodMatchRate = mRate
+		bestPathMatchRate = pRate
+		bestConditionMatchRate = cRate
+		bestRoute = rd
 	}
 
-	pathMatchRate := func(rd *RouteDefinition) int {
-		match, _ := rd.PathTemplate.Match(r.URL.Path)
-		if !match {
+	return bestRoute
+}
 
-			return noPathMatch
+// lookup returns route definitions which match on the request path.
+// The tree works on the decoded path, so candidates are checked again by PathTemplate.Match on the escaped path,
+// e.g. `/api/todo/1%2F2` reaches `/api/todo/{id:int}` in the tree but "1/2" is not an int.
+func (ro *Router) lookup(r *http.Request) []routeMatch {
+	matches := ro.routeTree().lookup(r.URL.Path, ro.mux.StrictPathMatch)
+	requestPath := encodedPathFromRequest(r)
+	filtered := matches[:0]
+	for _, match := range matches {
+		if ok, _ := match.entry.rd.PathTemplate.Match(requestPath); !ok {
+			continue
 		}
+		filtered = append(filtered, match)
+	}
 
-		tempPathTokens := rd.PathTemplate.splittedPathTemplate
-		reqPathTokens := strings.Split(r.URL.Path, "/")
-
-		if len(reqPathTokens) < len(tempPathTokens) {
-			// tempPath must not be longer than reqPath
-			return noPathMatch
-		}
+	return filtered
+}
 
-		var rate int
-		for i, token := range tempPathTokens {
-			if i == 0 {
-				// first token is always ""
-				continue
-			}
-			if rd.PathTemplate.isVariables[i] {
-				// variable token matches to everything.
-				rate += exactPathMatch
-				continue
-			}
-			if token == "" {
-				// "/a/" can match to "/a/c", but it's weaker than exact match.
-				rate += starPathMatch
-				continue
-			}
-			if token == reqPathTokens[i] {
-				rate += exactPathMatch
-			}
+// allowedMethods returns methods of route definitions which match to the request path.
+// It is used for building the Allow header when the request method does not match.
+func (ro *Router) allowedMethods(r *http.Request) []string {
+	var methods []string
+	found := make(map[string]bool)
+	add := func(method string) {
+		if found[method] {
+			return
 		}
-
-		return rate
+		found[method] = true
+		methods = append(methods, method)
 	}
 
-	for _, rd := range ro.handlers {
-		mRate := methodMatchRate(rd)
-		if mRate == noMethodMatch {
-			continue
-		} else if mRate < bestMethodMatchRate {
+	for _, match := range ro.lookup(r) {
+		rd := match.entry.rd
+		if !rd.matchConditions(r) {
 			continue
 		}
-
-		pRate := pathMatchRate(rd)
-		if pRate == noPathMatch {
-			continue
-		} else if pRate < bestPathMatchRate {
-			continue
+		add(rd.Method)
+		if rd.Method == "GET" {
+			add("HEAD")
 		}
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+	if ro.mux.AutoOptions {
+		add("OPTIONS")
+	}
+	sort.Strings(methods)
 
-		if bestMethodMatchRate == mRate && bestPathMatchRate == pRate {
-			continue
+	return methods
+}
+
+func (ro *Router) methodMatchRate(rd *RouteDefinition, r *http.Request) methodMatchRate {
+	if rd.Method == "*" {
+		if rd.Mounted() {
+			// the mounted handler decides on the method by itself.
+			return exactMethodMatch
 		}
+		return starMethodMatch
+	}
 
-		bestMethodMatchRate = mRate
-		bestPathMatchRate = pRate
-		bestRoute = rd
+	if rd.Method == "GET" && r.Method == "HEAD" {
+		return overloadMethodMatch
 	}
 
-	return bestRoute
+	if rd.Method == r.Method {
+		return exactMethodMatch
+	}
+
+	return noMethodMatch
 }
 
 // RouteDefinition is a definition of route handling.
@@ -183,15 +252,119 @@ type RouteDefinition struct {
 	Method           string
 	PathTemplate     *PathTemplate
 	HandlerContainer HandlerContainer
+	// Name is the name given by WithName. It is empty if the route has no name.
+	Name string
+	// Matchers are additional conditions of the route. see WithMatcher.
+	Matchers []RouteMatcher
+	// Status is the status code of successful responses given by WithStatus. It is zero if not given.
+	Status int
+
+	group *RouteGroup
+	plan  *handlerPlan
+}
+
+// Mounted reports whether the route is a http.Handler mounted by ServeMux.Mount.
+func (rd *RouteDefinition) Mounted() bool {
+	_, ok := rd.HandlerContainer.(*mountContainer)
+	return ok
+}
+
+// matchConditions reports whether all of the match conditions match to the request.
+func (rd *RouteDefinition) matchConditions(r *http.Request) bool {
+	for _, matcher := range rd.Matchers {
+		if !matcher.MatchRoute(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Middlewares returns the middlewares which run only for the route.
+// It contains middlewares of RouteGroup and HandlerContainer, but not ServeMux's.
+func (rd *RouteDefinition) Middlewares() []MiddlewareFunc {
+	var mws []MiddlewareFunc
+	var collect func(g *RouteGroup)
+	collect = func(g *RouteGroup) {
+		if g == nil {
+			return
+		}
+		collect(g.parent)
+		mws = append(mws, g.middlewares...)
+	}
+	collect(rd.group)
+
+	if mc, ok := rd.HandlerContainer.(MiddlewareContainer); ok {
+		mws = append(mws, mc.Middlewares()...)
+	}
+
+	return mws
 }
 
 // PathTemplate is a path with parameters template.
+// A parameter can have a constraint like `{id:int}`, `{id:uuid}` or `{slug:[a-z-]+}`.
+// PathParameters contains names of parameters, and PathConstraints contains constraints of those by name.
+// The last segment can be a catch-all parameter like `{path...}`, it captures the rest of the path.
 type PathTemplate struct {
 	PathTemplate         string
 	httpHandlePath       string
 	isVariables          []bool
+	variableNames        []string
+	constraints          []*PathConstraint
 	splittedPathTemplate []string
 	PathParameters       []string
+	PathConstraints      map[string]*PathConstraint
+	CatchAllParameter    string
+}
+
+// PathConstraint types.
+const (
+	PathConstraintInt    = "int"
+	PathConstraintUUID   = "uuid"
+	PathConstraintRegexp = "regexp"
+)
+
+// PathConstraint is a constraint of the path parameter value.
+type PathConstraint struct {
+	// Type is one of PathConstraintInt, PathConstraintUUID and PathConstraintRegexp.
+	Type string
+	// Pattern is the regular expression given by the template. It is set when Type is PathConstraintRegexp.
+	Pattern string
+
+	re *regexp.Regexp
+}
+
+var intConstraintRe = regexp.MustCompile("^[-+]?[0-9]+$")
+var uuidConstraintRe = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// ParsePathConstraint parses the constraint part of a path parameter.
+// The text other than "int" and "uuid" is treated as a regular expression which must match the whole value.
+func ParsePathConstraint(text string) (*PathConstraint, error) {
+	switch text {
+	case PathConstraintInt:
+		return &PathConstraint{Type: PathConstraintInt, re: intConstraintRe}, nil
+	case PathConstraintUUID:
+		return &PathConstraint{Type: PathConstraintUUID, re: uuidConstraintRe}, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + text + ")$")
+	if err != nil {
+		return nil, err
+	}
+
+	return &PathConstraint{Type: PathConstraintRegexp, Pattern: text, re: re}, nil
+}
+
+// Match reports whether the value satisfies the constraint.
+func (pc *PathConstraint) Match(value string) bool {
+	return pc.re.MatchString(value)
+}
+
+func (pc *PathConstraint) key() string {
+	if pc == nil {
+		return ""
+	}
+	return pc.Type + ":" + pc.Pattern
 }
 
 // Match checks whether PathTemplate matches the request path.
@@ -205,7 +378,14 @@ func (pt *PathTemplate) Match(requestPath string) (bool, map[string]string) {
 	}
 
 	requestPathSplitted := strings.Split(requestPath, "/")
-	if requiredLen, requestLen := len(pt.splittedPathTemplate), len(requestPathSplitted); requiredLen < requestLen {
+	if requiredLen, requestLen := len(pt.splittedPathTemplate), len(requestPathSplitted); pt.CatchAllParameter != "" {
+		if requestLen < requiredLen {
+			return false, nil
+		}
+		// join the rest of the path into the last segment
+		rest := strings.Join(requestPathSplitted[requiredLen-1:], "/")
+		requestPathSplitted = append(requestPathSplitted[0:requiredLen-1], rest)
+	} else if requiredLen < requestLen {
 		// I want to match /js/index.js to / :)
 		requestPathSplitted = requestPathSplitted[0:requiredLen]
 	} else if requiredLen != requestLen {
@@ -216,14 +396,17 @@ func (pt *PathTemplate) Match(requestPath string) (bool, map[string]string) {
 	for idx, s := range pt.splittedPathTemplate {
 		reqPart := requestPathSplitted[idx]
 		if pt.isVariables[idx] {
-			if reqPart == "" {
+			if reqPart == "" && pt.variableNames[idx] != pt.CatchAllParameter {
 				return false, nil
 			}
 			v, err := url.QueryUnescape(reqPart)
 			if err != nil {
 				v = reqPart
 			}
-			params[s[1:len(s)-1]] = v
+			if c := pt.constraints[idx]; c != nil && !c.Match(v) {
+				return false, nil
+			}
+			params[pt.variableNames[idx]] = v
 		} else if s != reqPart {
 			return false, nil
 		} else {
@@ -234,7 +417,66 @@ func (pt *PathTemplate) Match(requestPath string) (bool, map[string]string) {
 	return true, params
 }
 
+// PathWithoutConstraints returns the path template whose parameters have no constraints and no catch-all marks.
+// e.g. `/api/todo/{id}` for `/api/todo/{id:int}`, `/static/{path}` for `/static/{path...}`.
+func (pt *PathTemplate) PathWithoutConstraints() string {
+	if len(pt.PathConstraints) == 0 && pt.CatchAllParameter == "" {
+		return pt.PathTemplate
+	}
+
+	tokens := make([]string, len(pt.splittedPathTemplate))
+	for idx, s := range pt.splittedPathTemplate {
+		if pt.isVariables[idx] {
+			s = "{" + pt.variableNames[idx] + "}"
+		}
+		tokens[idx] = s
+	}
+
+	return strings.Join(tokens, "/")
+}
+
+// Expand builds the path by substituting parameters of the template with params.
+// Values are escaped as path segments, but slashes in the value of the catch-all parameter are kept.
+// It returns an error if a parameter is missing or does not satisfy its constraint.
+func (pt *PathTemplate) Expand(params map[string]string) (string, error) {
+	tokens := make([]string, len(pt.splittedPathTemplate))
+	for idx, s := range pt.splittedPathTemplate {
+		if !pt.isVariables[idx] {
+			tokens[idx] = s
+			continue
+		}
+
+		name := pt.variableNames[idx]
+		v, ok := params[name]
+		if !ok || (v == "" && name != pt.CatchAllParameter) {
+			return "", fmt.Errorf("path parameter is required: %s", name)
+		}
+		if c := pt.constraints[idx]; c != nil && !c.Match(v) {
+			return "", fmt.Errorf("path parameter does not satisfy the constraint: %s=%s", name, v)
+		}
+
+		if name == pt.CatchAllParameter {
+			segments := strings.Split(v, "/")
+			for i, segment := range segments {
+				segments[i] = escapePathSegment(segment)
+			}
+			tokens[idx] = strings.Join(segments, "/")
+		} else {
+			tokens[idx] = escapePathSegment(v)
+		}
+	}
+
+	return strings.Join(tokens, "/"), nil
+}
+
+// escapePathSegment escapes the value to be decoded by PathTemplate.Match.
+// "+" is also escaped because Match decodes it as a space.
+func escapePathSegment(v string) string {
+	return strings.Replace(url.PathEscape(v), "+", "%2B", -1)
+}
+
 // ParsePathTemplate parses path string to PathTemplate.
+// It panics if a constraint of parameters is an invalid regular expression.
 func ParsePathTemplate(pathTmpl string) *PathTemplate {
 	tmpl := &PathTemplate{}
 	tmpl.PathTemplate = pathTmpl
@@ -247,12 +489,33 @@ func ParsePathTemplate(pathTmpl string) *PathTemplate {
 
 	tmpl.splittedPathTemplate = strings.Split(pathTmpl, "/")
 	tmpl.isVariables = make([]bool, len(tmpl.splittedPathTemplate))
+	tmpl.variableNames = make([]string, len(tmpl.splittedPathTemplate))
+	tmpl.constraints = make([]*PathConstraint, len(tmpl.splittedPathTemplate))
 
 	re := regexp.MustCompile("^\\{(.+)\\}$")
 	for idx, param := range tmpl.splittedPathTemplate {
 		if re.MatchString(param) {
 			key := re.FindStringSubmatch(param)[1]
+			if strings.HasSuffix(key, "...") {
+				if idx != len(tmpl.splittedPathTemplate)-1 {
+					panic(fmt.Sprintf("catch-all parameter must be the last segment: %s", pathTmpl))
+				}
+				key = strings.TrimSuffix(key, "...")
+				tmpl.CatchAllParameter = key
+			} else if i := strings.Index(key, ":"); i != -1 {
+				c, err := ParsePathConstraint(key[i+1:])
+				if err != nil {
+					panic(fmt.Sprintf("invalid path constraint in %s: %s", pathTmpl, err.Error()))
+				}
+				key = key[:i]
+				tmpl.constraints[idx] = c
+				if tmpl.PathConstraints == nil {
+					tmpl.PathConstraints = make(map[string]*PathConstraint)
+				}
+				tmpl.PathConstraints[key] = c
+			}
 			tmpl.PathParameters = append(tmpl.PathParameters, key)
+			tmpl.variableNames[idx] = key
 			tmpl.isVariables[idx] = true
 			continue
 		}
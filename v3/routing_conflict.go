@@ -0,0 +1,193 @@
+package ucon
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// RouteConflictMode is the way to report conflicts of route definitions at ServeMux.Prepare.
+type RouteConflictMode int
+
+const (
+	// RouteConflictIgnore does not check conflicts.
+	RouteConflictIgnore RouteConflictMode = iota
+	// RouteConflictWarn logs conflicts.
+	RouteConflictWarn
+	// RouteConflictStrict panics if there are conflicts.
+	RouteConflictStrict
+)
+
+// Types of RouteConflict.
+const (
+	// RouteConflictDuplicated means that 2 routes have the same method and the same path template.
+	RouteConflictDuplicated = "duplicated"
+	// RouteConflictShadowed means that the later route is never picked because the earlier route always wins.
+	RouteConflictShadowed = "shadowed"
+	// RouteConflictAmbiguousParameter means that parameters at the same position have different names.
+	RouteConflictAmbiguousParameter = "ambiguous parameter"
+)
+
+// RouteConflict is a conflict between 2 route definitions.
+// Route is added to the router earlier than Other.
+type RouteConflict struct {
+	Type  string
+	Route *RouteDefinition
+	Other *RouteDefinition
+}
+
+func (c *RouteConflict) String() string {
+	return fmt.Sprintf("%s: %s %s and %s %s", c.Type, c.Route.Method, c.Route.PathTemplate.PathTemplate, c.Other.Method, c.Other.PathTemplate.PathTemplate)
+}
+
+// RouteConflicts analyses registered route definitions and returns their conflicts.
+// Routes which have match conditions are not checked for duplicated and shadowed, because conditions can not be compared.
+func (m *ServeMux) RouteConflicts() []*RouteConflict {
+	var conflicts []*RouteConflict
+	ambiguous := make(map[string]bool)
+
+	rds := m.router.handlers
+	for i, rd := range rds {
+		for _, other := range rds[i+1:] {
+			pt, otherPt := rd.PathTemplate, other.PathTemplate
+
+			if pt != otherPt && hasAmbiguousParameter(pt, otherPt) {
+				key := pt.PathTemplate + " " + otherPt.PathTemplate
+				if !ambiguous[key] {
+					ambiguous[key] = true
+					conflicts = append(conflicts, &RouteConflict{Type: RouteConflictAmbiguousParameter, Route: rd, Other: other})
+				}
+			}
+
+			if rd.Method != other.Method || len(rd.Matchers) != 0 || len(other.Matchers) != 0 {
+				continue
+			}
+			if isSameRoutePath(pt, otherPt) {
+				conflicts = append(conflicts, &RouteConflict{Type: RouteConflictDuplicated, Route: rd, Other: other})
+			} else if coversRoutePath(pt, otherPt) {
+				conflicts = append(conflicts, &RouteConflict{Type: RouteConflictShadowed, Route: rd, Other: other})
+			}
+		}
+	}
+
+	return conflicts
+}
+
+func (m *ServeMux) checkRouteConflicts() {
+	if m.RouteConflictMode == RouteConflictIgnore {
+		return
+	}
+
+	conflicts := m.RouteConflicts()
+	if len(conflicts) == 0 {
+		return
+	}
+
+	messages := make([]string, len(conflicts))
+	for idx, c := range conflicts {
+		messages[idx] = c.String()
+	}
+	if m.RouteConflictMode == RouteConflictStrict {
+		panic(fmt.Sprintf("route conflicts are found:\n%s", strings.Join(messages, "\n")))
+	}
+	for _, message := range messages {
+		log.Printf("[ucon] route conflict %s", message)
+	}
+}
+
+// isStaticPath reports whether the template has no parameters. Those match to the request path by prefix.
+func isStaticPath(pt *PathTemplate) bool {
+	return pt.PathTemplate == pt.httpHandlePath
+}
+
+// isSameRoutePath reports whether 2 templates match to the same request paths.
+func isSameRoutePath(a *PathTemplate, b *PathTemplate) bool {
+	if isStaticPath(a) || isStaticPath(b) {
+		return a.PathTemplate == b.PathTemplate
+	}
+	if len(a.splittedPathTemplate) != len(b.splittedPathTemplate) {
+		return false
+	}
+
+	for idx, token := range a.splittedPathTemplate {
+		if a.isVariables[idx] != b.isVariables[idx] {
+			return false
+		}
+		if !a.isVariables[idx] {
+			if token != b.splittedPathTemplate[idx] {
+				return false
+			}
+			continue
+		}
+		if a.isCatchAll(idx) != b.isCatchAll(idx) || a.constraints[idx].key() != b.constraints[idx].key() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// coversRoutePath reports whether a matches to all request paths which b matches to, with the same or higher path match rate.
+// A static b is compared by its segments, a longer request path which matches to b by prefix has a lower rate.
+func coversRoutePath(a *PathTemplate, b *PathTemplate) bool {
+	if isStaticPath(a) {
+		return false
+	}
+	if len(a.splittedPathTemplate) != len(b.splittedPathTemplate) {
+		return false
+	}
+
+	for idx, token := range a.splittedPathTemplate {
+		bToken := b.splittedPathTemplate[idx]
+		if !a.isVariables[idx] {
+			if b.isVariables[idx] || token != bToken {
+				return false
+			}
+			continue
+		}
+		if a.isCatchAll(idx) != b.isCatchAll(idx) {
+			return false
+		}
+
+		c := a.constraints[idx]
+		if !b.isVariables[idx] {
+			// a parameter and an equal literal have the same rate.
+			if bToken == "" || (c != nil && !c.Match(bToken)) {
+				return false
+			}
+			continue
+		}
+		if c != nil && c.key() != b.constraints[idx].key() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasAmbiguousParameter reports whether 2 templates have parameters which have different names after the same path.
+func hasAmbiguousParameter(a *PathTemplate, b *PathTemplate) bool {
+	for idx, token := range a.splittedPathTemplate {
+		if len(b.splittedPathTemplate) <= idx || a.isVariables[idx] != b.isVariables[idx] {
+			return false
+		}
+		if !a.isVariables[idx] {
+			if token != b.splittedPathTemplate[idx] {
+				return false
+			}
+			continue
+		}
+		if a.constraints[idx].key() != b.constraints[idx].key() {
+			return false
+		}
+		if a.variableNames[idx] != b.variableNames[idx] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (pt *PathTemplate) isCatchAll(idx int) bool {
+	return pt.isVariables[idx] && pt.variableNames[idx] == pt.CatchAllParameter
+}
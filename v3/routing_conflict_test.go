@@ -0,0 +1,73 @@
+package ucon
+
+import (
+	"testing"
+)
+
+func TestServeMuxRouteConflicts(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	h := func() {}
+
+	HandleFunc("GET,PUT", "/api/todo/{id}", h)
+	HandleFunc("GET", "/api/todo/{id}", h)
+	HandleFunc("PUT", "/api/todo/{todoID}", h)
+	HandleFunc("GET", "/api/todo/{id:int}", h)
+	HandleFunc("GET", "/api/todo/new", h)
+	HandleFunc("GET", "/api/todo", h)
+	HandleFunc("GET", "/api/todo", h)
+	HandleFunc("GET", "/api/user/{id:int}", h)
+	HandleFunc("GET", "/api/user/{id}", h)
+	HandleFunc("GET", "/api/user/me", h)
+	HandleFunc("GET", "/api/user/{id}", h, WithHost("admin.example.com"))
+	HandleFunc("GET", "/static/{path...}", h)
+	HandleFunc("GET", "/static/{file...}", h)
+
+	conflicts := DefaultMux.RouteConflicts()
+
+	expected := []string{
+		"duplicated: GET /api/todo/{id} and GET /api/todo/{id}",
+		"ambiguous parameter: GET /api/todo/{id} and PUT /api/todo/{todoID}",
+		"shadowed: GET /api/todo/{id} and GET /api/todo/{id:int}",
+		"shadowed: GET /api/todo/{id} and GET /api/todo/new",
+		"duplicated: PUT /api/todo/{id} and PUT /api/todo/{todoID}",
+		"shadowed: GET /api/todo/{id} and GET /api/todo/{id:int}",
+		"shadowed: GET /api/todo/{id} and GET /api/todo/new",
+		"duplicated: GET /api/todo and GET /api/todo",
+		"shadowed: GET /api/user/{id} and GET /api/user/me",
+		"ambiguous parameter: GET /static/{path...} and GET /static/{file...}",
+		"duplicated: GET /static/{path...} and GET /static/{file...}",
+	}
+	if v := len(conflicts); v != len(expected) {
+		for _, c := range conflicts {
+			t.Log(c.String())
+		}
+		t.Fatalf("unexpected: %v", v)
+	}
+	for idx, c := range conflicts {
+		if v := c.String(); v != expected[idx] {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+}
+
+func TestServeMuxPrepare_routeConflictMode(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	HandleFunc("GET", "/api/todo/{id}", func() {})
+	HandleFunc("GET", "/api/todo/{id}", func() {})
+
+	// RouteConflictIgnore is the default
+	DefaultMux.Prepare()
+
+	DefaultMux.RouteConflictMode = RouteConflictWarn
+	DefaultMux.Prepare()
+
+	DefaultMux.RouteConflictMode = RouteConflictStrict
+	defer func() {
+		if err := recover(); err == nil {
+			t.Errorf("unexpected: %v", err)
+		}
+	}()
+	DefaultMux.Prepare()
+}
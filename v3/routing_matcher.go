@@ -0,0 +1,101 @@
+package ucon
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// RouteMatcher is an additional condition of RouteDefinition other than the method and the path.
+type RouteMatcher interface {
+	MatchRoute(r *http.Request) bool
+}
+
+// RouteMatcherFunc is an adapter to use an ordinary function as RouteMatcher.
+type RouteMatcherFunc func(r *http.Request) bool
+
+// MatchRoute calls f(r).
+func (f RouteMatcherFunc) MatchRoute(r *http.Request) bool {
+	return f(r)
+}
+
+// NewHostMatcher returns RouteMatcher which matches to the host of the request.
+// A label of the pattern can be `*` which matches to any one label, e.g. `*.example.com`.
+// If the pattern has no port, the port of the request is ignored.
+func NewHostMatcher(pattern string) RouteMatcher {
+	pattern = strings.ToLower(pattern)
+	_, _, err := net.SplitHostPort(pattern)
+	withPort := err == nil
+	patternLabels := strings.Split(pattern, ".")
+
+	return RouteMatcherFunc(func(r *http.Request) bool {
+		host := strings.ToLower(r.Host)
+		if !withPort {
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+		}
+
+		labels := strings.Split(host, ".")
+		if len(labels) != len(patternLabels) {
+			return false
+		}
+		for idx, label := range patternLabels {
+			if label == "*" && labels[idx] != "" {
+				continue
+			}
+			if label != labels[idx] {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+// NewHeaderMatcher returns RouteMatcher which matches to the request header.
+// The header value is split by commas and its parameters after `;` are ignored,
+// then it matches if one of them equals to the value case-insensitively.
+// e.g. the value `application/vnd.foo.v2+json` matches to `Accept: text/html, application/vnd.foo.v2+json;q=0.9`.
+// If the value is empty, it matches if the header exists.
+func NewHeaderMatcher(name string, value string) RouteMatcher {
+	return RouteMatcherFunc(func(r *http.Request) bool {
+		values, ok := r.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return false
+		}
+		if value == "" {
+			return true
+		}
+
+		for _, v := range values {
+			for _, element := range strings.Split(v, ",") {
+				if idx := strings.Index(element, ";"); idx != -1 {
+					element = element[:idx]
+				}
+				if strings.EqualFold(strings.TrimSpace(element), value) {
+					return true
+				}
+			}
+		}
+
+		return false
+	})
+}
+
+// NewHeaderRegexpMatcher returns RouteMatcher which matches if one of the request header values matches to the regular expression.
+// It panics if the pattern is invalid.
+func NewHeaderRegexpMatcher(name string, pattern string) RouteMatcher {
+	re := regexp.MustCompile(pattern)
+
+	return RouteMatcherFunc(func(r *http.Request) bool {
+		for _, v := range r.Header[http.CanonicalHeaderKey(name)] {
+			if re.MatchString(v) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
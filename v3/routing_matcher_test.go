@@ -0,0 +1,141 @@
+package ucon
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNewHostMatcher(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		host     string
+		expected bool
+	}{
+		{"admin.example.com", "admin.example.com", true},
+		{"admin.example.com", "ADMIN.example.com:8080", true},
+		{"admin.example.com", "api.example.com", false},
+		{"*.example.com", "api.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+		{"localhost:8080", "localhost:8080", true},
+		{"localhost:8080", "localhost:9090", false},
+	}
+	for _, c := range cases {
+		r, err := http.NewRequest("GET", "http://"+c.host+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := NewHostMatcher(c.pattern).MatchRoute(r); v != c.expected {
+			t.Errorf("unexpected: %v %s %s", v, c.pattern, c.host)
+		}
+	}
+}
+
+func TestNewHeaderMatcher(t *testing.T) {
+	cases := []struct {
+		value    string
+		header   string
+		expected bool
+	}{
+		{"application/vnd.foo.v2+json", "application/vnd.foo.v2+json", true},
+		{"application/vnd.foo.v2+json", "text/html, application/vnd.foo.v2+json;q=0.9", true},
+		{"application/vnd.foo.v2+json", "application/vnd.foo.v1+json", false},
+		{"application/vnd.foo.v2+json", "", false},
+		{"", "text/html", true},
+	}
+	for _, c := range cases {
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.header != "" {
+			r.Header.Set("Accept", c.header)
+		}
+		if v := NewHeaderMatcher("accept", c.value).MatchRoute(r); v != c.expected {
+			t.Errorf("unexpected: %v %s %s", v, c.value, c.header)
+		}
+	}
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Accept", "application/vnd.foo.v3+json")
+	if v := NewHeaderRegexpMatcher("Accept", `vnd\.foo\.v[23]`).MatchRoute(r); !v {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := NewHeaderRegexpMatcher("Accept", `vnd\.foo\.v1`).MatchRoute(r); v {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestRouterPickupBestRouteDefinition_withMatchers(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	h := func() {}
+
+	HandleFunc("GET", "/api/todo", h)
+	HandleFunc("GET", "/api/todo", h, WithHost("admin.example.com"))
+	HandleFunc("GET", "/api/todo", h, WithHost("admin.example.com"), WithHeader("Accept", "application/vnd.foo.v2+json"))
+	HandleFunc("GET", "/api/todo/{id}", h, WithMatcher(RouteMatcherFunc(func(r *http.Request) bool {
+		return r.URL.Query().Get("debug") != ""
+	})))
+	HandleFunc("POST", "/admin", h, WithHost("admin.example.com"))
+
+	DefaultMux.Prepare()
+
+	cases := []struct {
+		method   string
+		url      string
+		accept   string
+		expected int
+	}{
+		{"GET", "http://api.example.com/api/todo", "", 0},
+		{"GET", "http://admin.example.com/api/todo", "", 1},
+		{"GET", "http://admin.example.com/api/todo", "application/vnd.foo.v2+json", 2},
+		{"GET", "http://api.example.com/api/todo", "application/vnd.foo.v2+json", 0},
+		// the path rate is stronger than conditions
+		{"GET", "http://admin.example.com/api/todo/1?debug=1", "application/vnd.foo.v2+json", 3},
+		{"GET", "http://admin.example.com/api/todo/1", "application/vnd.foo.v2+json", 2},
+		{"POST", "http://api.example.com/admin", "", -1},
+		{"POST", "http://admin.example.com/admin", "", 4},
+	}
+	for _, c := range cases {
+		r, err := http.NewRequest(c.method, c.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		rd := DefaultMux.router.pickupBestRouteDefinition(r)
+		if c.expected == -1 {
+			if rd != nil {
+				t.Errorf("unexpected: %v", describeRoute(rd))
+			}
+			continue
+		}
+		if rd != DefaultMux.router.handlers[c.expected] {
+			t.Errorf("unexpected: %s %s %s", c.url, c.accept, describeRoute(rd))
+		}
+	}
+}
+
+func TestRouterServeHTTP_methodNotAllowedWithMatchers(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	h := func() {}
+
+	HandleFunc("GET", "/api/todo", h)
+	HandleFunc("POST", "/api/todo", h, WithHost("admin.example.com"))
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "PUT", "/api/todo", nil)
+	if v := resp.StatusCode; v != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := resp.Header.Get("Allow"); v != "GET, HEAD" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
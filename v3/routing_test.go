@@ -1,8 +1,10 @@
 package ucon
 
 import (
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
@@ -470,3 +472,412 @@ func TestPathTemplateMatch_failure(t *testing.T) {
 		t.Fatalf("unexpected")
 	}
 }
+
+func TestRouterServeHTTP_methodNotAllowed(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	h := func() {}
+	HandleFunc("GET", "/api/todo/{id}", h)
+	HandleFunc("PUT", "/api/todo/{id}", h)
+	HandleFunc("DELETE", "/api/todo/{id}", h)
+	HandleFunc("POST", "/api/todos", h)
+
+	DefaultMux.Prepare()
+
+	{
+		resp := MakeHandlerTestBed(t, "PATCH", "/api/todo/1", nil)
+		if v := resp.StatusCode; v != http.StatusMethodNotAllowed {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := resp.Header.Get("Allow"); v != "DELETE, GET, HEAD, PUT" {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+	{
+		resp := MakeHandlerTestBed(t, "OPTIONS", "/api/todo/1", nil)
+		if v := resp.StatusCode; v != http.StatusMethodNotAllowed {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+	{
+		resp := MakeHandlerTestBed(t, "GET", "/api/foo", nil)
+		if v := resp.StatusCode; v != http.StatusNotFound {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := resp.Header.Get("Allow"); v != "" {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+}
+
+func TestRouterServeHTTP_autoOptions(t *testing.T) {
+	DefaultMux = NewServeMux()
+	DefaultMux.AutoOptions = true
+	Orthodox()
+
+	h := func() {}
+	HandleFunc("GET", "/api/todo", h)
+	HandleFunc("POST", "/api/todo", h)
+	HandleFunc("OPTIONS", "/api/explicit", func(w http.ResponseWriter) {
+		w.Header().Set("Allow", "EXPLICIT")
+	})
+	HandleFunc("GET", "/api/explicit", h)
+
+	DefaultMux.Prepare()
+
+	{
+		resp := MakeHandlerTestBed(t, "OPTIONS", "/api/todo", nil)
+		if v := resp.StatusCode; v != http.StatusOK {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := resp.Header.Get("Allow"); v != "GET, HEAD, OPTIONS, POST" {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+	{
+		resp := MakeHandlerTestBed(t, "DELETE", "/api/todo", nil)
+		if v := resp.StatusCode; v != http.StatusMethodNotAllowed {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := resp.Header.Get("Allow"); v != "GET, HEAD, OPTIONS, POST" {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+	{
+		resp := MakeHandlerTestBed(t, "OPTIONS", "/api/explicit", nil)
+		if v := resp.Header.Get("Allow"); v != "EXPLICIT" {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+}
+
+func TestRouterServeHTTP_defaultErrorHandlers(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	HandleFunc("GET", "/api/todo", func() {})
+	HandleFunc("GET", "/api/error", func() {})
+	DefaultMux.Middleware(func(b *Bubble) error {
+		if b.R.URL.Path == "/api/error" {
+			return errors.New("internal detail")
+		}
+		return b.Next()
+	})
+
+	DefaultMux.Prepare()
+
+	cases := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{"GET", "/foo", http.StatusNotFound, `{"code":404,"message":"Not Found"}`},
+		{"POST", "/api/todo", http.StatusMethodNotAllowed, `{"code":405,"message":"Method Not Allowed"}`},
+		{"GET", "/api/error", http.StatusInternalServerError, `{"code":500,"message":"Internal Server Error"}`},
+	}
+	for _, c := range cases {
+		resp := MakeHandlerTestBed(t, c.method, c.path, nil)
+		if v := resp.StatusCode; v != c.code {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := resp.Header.Get("Content-Type"); v != "application/json; charset=UTF-8" {
+			t.Errorf("unexpected: %v", v)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := string(body); v != c.body {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+}
+
+func TestRouterServeHTTP_customErrorHandlers(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	DefaultMux.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	DefaultMux.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(w.Header().Get("Allow")))
+	})
+	DefaultMux.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+	}
+
+	HandleFunc("GET", "/api/todo", func() {})
+	HandleFunc("GET", "/api/error", func() {})
+	DefaultMux.Middleware(func(b *Bubble) error {
+		if b.R.URL.Path == "/api/error" {
+			return errors.New("internal detail")
+		}
+		return b.Next()
+	})
+
+	DefaultMux.Prepare()
+
+	cases := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{"GET", "/foo", http.StatusTeapot, ""},
+		{"POST", "/api/todo", http.StatusOK, "GET, HEAD"},
+		{"GET", "/api/error", http.StatusServiceUnavailable, "internal detail"},
+	}
+	for _, c := range cases {
+		resp := MakeHandlerTestBed(t, c.method, c.path, nil)
+		if v := resp.StatusCode; v != c.code {
+			t.Errorf("unexpected: %v", v)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := string(body); v != c.body {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+}
+
+func TestParsePathTemplate_withConstraints(t *testing.T) {
+	pt := ParsePathTemplate("/api/{id:int}/{uuid:uuid}/{slug:[a-z-]{2,}}/{name}")
+
+	if v := strings.Join(pt.PathParameters, ","); v != "id,uuid,slug,name" {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := len(pt.PathConstraints); v != 3 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := pt.PathConstraints["id"].Type; v != PathConstraintInt {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := pt.PathConstraints["uuid"].Type; v != PathConstraintUUID {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := pt.PathConstraints["slug"]; v.Type != PathConstraintRegexp || v.Pattern != "[a-z-]{2,}" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if _, ok := pt.PathConstraints["name"]; ok {
+		t.Errorf("unexpected: %v", ok)
+	}
+
+	{
+		match, params := pt.Match("/api/12/6ba7b810-9dad-11d1-80b4-00c04fd430c8/foo-bar/x")
+		if !match {
+			t.Fatalf("unexpected")
+		}
+		if v := params["id"]; v != "12" {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := params["slug"]; v != "foo-bar" {
+			t.Errorf("unexpected: %v", v)
+		}
+	}
+	for _, path := range []string{
+		"/api/a/6ba7b810-9dad-11d1-80b4-00c04fd430c8/foo-bar/x",
+		"/api/12/6ba7b810/foo-bar/x",
+		"/api/12/6ba7b810-9dad-11d1-80b4-00c04fd430c8/f/x",
+		"/api/12/6ba7b810-9dad-11d1-80b4-00c04fd430c8/Foo/x",
+	} {
+		if match, _ := pt.Match(path); match {
+			t.Errorf("unexpected: %v", path)
+		}
+	}
+}
+
+func TestParsePathTemplate_withInvalidConstraint(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Errorf("unexpected: %v", err)
+		}
+	}()
+
+	ParsePathTemplate("/api/{id:[0-9}")
+}
+
+func TestRouterPickupBestRouteDefinition_withConstraints(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	h := func() {}
+
+	HandleFunc("GET", "/api/todo/{slug:[a-z]+}", h)
+	HandleFunc("GET", "/api/todo/{id:int}", h)
+	HandleFunc("GET", "/api/todo/{key}", h)
+
+	cases := []struct {
+		path     string
+		template string
+	}{
+		{"/api/todo/1", "/api/todo/{id:int}"},
+		{"/api/todo/special", "/api/todo/{slug:[a-z]+}"},
+		{"/api/todo/Special1", "/api/todo/{key}"},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd := DefaultMux.router.pickupBestRouteDefinition(req)
+		if rd == nil {
+			t.Fatalf("unexpected")
+		}
+		if v := rd.PathTemplate.PathTemplate; v != c.template {
+			t.Errorf("unexpected: %v <- %v", v, c.path)
+		}
+	}
+}
+
+func TestPathTemplatePathWithoutConstraints(t *testing.T) {
+	pt := ParsePathTemplate("/api/{id:int}/{slug:[a-z]{2}}/{name}")
+	if v := pt.PathWithoutConstraints(); v != "/api/{id}/{slug}/{name}" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	pt = ParsePathTemplate("/static/{path...}")
+	if v := pt.PathWithoutConstraints(); v != "/static/{path}" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestPathTemplateMatch_catchAll(t *testing.T) {
+	pt := ParsePathTemplate("/static/{path...}")
+	if v := pt.CatchAllParameter; v != "path" {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := strings.Join(pt.PathParameters, ","); v != "path" {
+		t.Fatalf("unexpected: %v", v)
+	}
+
+	cases := []struct {
+		path  string
+		match bool
+		value string
+	}{
+		{"/static/js/index.js", true, "js/index.js"},
+		{"/static/index.js", true, "index.js"},
+		{"/static/", true, ""},
+		{"/static/a%20b/c", true, "a b/c"},
+		{"/static", false, ""},
+		{"/api/index.js", false, ""},
+	}
+	for _, c := range cases {
+		match, params := pt.Match(c.path)
+		if match != c.match {
+			t.Errorf("unexpected: %v <- %v", match, c.path)
+			continue
+		}
+		if v := params["path"]; v != c.value {
+			t.Errorf("unexpected: %v <- %v", v, c.path)
+		}
+	}
+}
+
+func TestParsePathTemplate_withCatchAllInMiddle(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Errorf("unexpected: %v", err)
+		}
+	}()
+
+	ParsePathTemplate("/static/{path...}/index.js")
+}
+
+func TestRouterPickupBestRouteDefinition_withCatchAll(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		DefaultMux = NewServeMux()
+		DefaultMux.StrictPathMatch = strict
+
+		h := func() {}
+
+		HandleFunc("GET", "/", h)
+		HandleFunc("GET", "/static/{path...}", h)
+		HandleFunc("GET", "/static/{name}", h)
+		HandleFunc("GET", "/api/{id}", h)
+
+		cases := []struct {
+			path     string
+			template string
+			strict   string
+		}{
+			{"/", "/", "/"},
+			{"/index.js", "/", ""},
+			{"/static/index.js", "/static/{name}", "/static/{name}"},
+			// extra segments are truncated in non-strict mode, so {name} is stronger than the catch-all.
+			{"/static/js/index.js", "/static/{name}", "/static/{path...}"},
+			{"/static/", "/static/{path...}", "/static/{path...}"},
+			{"/api/1", "/api/{id}", "/api/{id}"},
+			{"/api/1/2", "/api/{id}", ""},
+		}
+		for _, c := range cases {
+			req, err := http.NewRequest("GET", c.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := c.template
+			if strict {
+				expected = c.strict
+			}
+			rd := DefaultMux.router.pickupBestRouteDefinition(req)
+			if rd == nil && expected == "" {
+				continue
+			} else if rd == nil {
+				t.Errorf("unexpected: nil <- %v, strict=%v", c.path, strict)
+				continue
+			}
+			if v := rd.PathTemplate.PathTemplate; v != expected {
+				t.Errorf("unexpected: %v <- %v, strict=%v", v, c.path, strict)
+			}
+		}
+	}
+}
+
+func TestRouterServeHTTP_catchAll(t *testing.T) {
+	DefaultMux = NewServeMux()
+	DefaultMux.StrictPathMatch = true
+	Orthodox()
+
+	type Req struct {
+		Path string `json:"path"`
+	}
+	HandleFunc("GET", "/files/{path...}", func(req *Req) (*ResponseOfRoutingInfoAddHandlers, error) {
+		return &ResponseOfRoutingInfoAddHandlers{Text: req.Path}, nil
+	})
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "GET", "/files/a/b/c.txt", nil)
+	if v := resp.StatusCode; v != 200 {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(body); v != `{"text":"a/b/c.txt"}` {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestRouterServeHTTP_withUnmatchedConstraint(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("GET", "/api/todo/{id:int}", func() {})
+
+	DefaultMux.Prepare()
+
+	// the tree sees "+1" and "1", but PathTemplate.Match sees " 1" and "1/2"
+	for _, path := range []string{"/api/todo/+1", "/api/todo/1%2F2"} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		DefaultMux.ServeHTTP(w, r)
+		if v := w.Code; v != http.StatusNotFound {
+			t.Errorf("unexpected: %v <- %v", v, path)
+		}
+	}
+}
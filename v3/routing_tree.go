@@ -0,0 +1,210 @@
+package ucon
+
+import (
+	"strings"
+)
+
+// routeTree is a tree of path segments precompiled from route definitions.
+// It finds route definitions which match to the request path without scanning all of them.
+// Selecting the best one from the found definitions is the job of Router.
+type routeTree struct {
+	root *routeNode
+	size int
+}
+
+type routeNode struct {
+	children map[string]*routeNode
+	params   []*paramChild
+
+	// routes are definitions which have parameters and whose template ends at this node.
+	routes []*routeEntry
+	// catchAlls are definitions whose last segment is a catch-all parameter.
+	// Those match if the request has one or more segments after this node.
+	catchAlls []*routeEntry
+	// prefixes are definitions which have no parameters, keyed by its last segment.
+	// Those match if the next request segment has the key as a prefix, same as PathTemplate.Match.
+	prefixes map[string][]*routeEntry
+}
+
+type paramChild struct {
+	constraint *PathConstraint
+	node       *routeNode
+}
+
+type routeEntry struct {
+	index int
+	rd    *RouteDefinition
+}
+
+type routeMatch struct {
+	entry *routeEntry
+	rate  int
+}
+
+func newRouteTree(rds []*RouteDefinition) *routeTree {
+	t := &routeTree{
+		root: &routeNode{},
+		size: len(rds),
+	}
+	for idx, rd := range rds {
+		t.add(idx, rd)
+	}
+
+	return t
+}
+
+func (t *routeTree) add(idx int, rd *RouteDefinition) {
+	pt := rd.PathTemplate
+	tokens := pt.splittedPathTemplate
+	entry := &routeEntry{index: idx, rd: rd}
+
+	node := t.root
+	if pt.PathTemplate == pt.httpHandlePath {
+		// static path. see PathTemplate.Match
+		for _, token := range tokens[:len(tokens)-1] {
+			node = node.literalChild(token)
+		}
+		last := tokens[len(tokens)-1]
+		if node.prefixes == nil {
+			node.prefixes = make(map[string][]*routeEntry)
+		}
+		node.prefixes[last] = append(node.prefixes[last], entry)
+		return
+	}
+
+	for i, token := range tokens {
+		if pt.isVariables[i] && pt.variableNames[i] == pt.CatchAllParameter {
+			node.catchAlls = append(node.catchAlls, entry)
+			return
+		}
+		if pt.isVariables[i] {
+			node = node.paramChild(pt.constraints[i])
+			continue
+		}
+		node = node.literalChild(token)
+	}
+	node.routes = append(node.routes, entry)
+}
+
+func (n *routeNode) literalChild(token string) *routeNode {
+	if n.children == nil {
+		n.children = make(map[string]*routeNode)
+	}
+	child, ok := n.children[token]
+	if !ok {
+		child = &routeNode{}
+		n.children[token] = child
+	}
+
+	return child
+}
+
+func (n *routeNode) paramChild(c *PathConstraint) *routeNode {
+	for _, child := range n.params {
+		if child.constraint.key() == c.key() {
+			return child.node
+		}
+	}
+	child := &paramChild{constraint: c, node: &routeNode{}}
+	n.params = append(n.params, child)
+
+	return child.node
+}
+
+// lookup returns route definitions which match to the request path with its path match rate.
+// If strict is true, definitions without a catch-all parameter must match to the whole path.
+// The results are ordered by the registration order.
+func (t *routeTree) lookup(path string, strict bool) []routeMatch {
+	matches := t.root.collect(path, true, strict, 0, 0, nil)
+
+	// insertion sort. matches are usually a few.
+	for i := 1; i < len(matches); i++ {
+		for j := i; 0 < j && matches[j].entry.index < matches[j-1].entry.index; j-- {
+			matches[j], matches[j-1] = matches[j-1], matches[j]
+		}
+	}
+
+	return matches
+}
+
+// collect walks the tree along request path segments.
+// rest is the request path which has not been consumed yet, and hasToken reports whether rest still has a segment.
+// The rate is calculated in the same way as the Router rules, the first segment is not counted.
+func (n *routeNode) collect(rest string, hasToken bool, strict bool, depth int, rate int, matches []routeMatch) []routeMatch {
+	if !strict || !hasToken {
+		for _, entry := range n.routes {
+			if rate != noPathMatch {
+				matches = append(matches, routeMatch{entry: entry, rate: rate})
+			}
+		}
+	}
+
+	if !hasToken {
+		return matches
+	}
+
+	for _, entry := range n.catchAlls {
+		cRate := rate
+		if depth != 0 {
+			cRate += starPathMatch
+		}
+		if cRate != noPathMatch {
+			matches = append(matches, routeMatch{entry: entry, rate: cRate})
+		}
+	}
+
+	token, next, hasNext := rest, "", false
+	if idx := strings.IndexByte(rest, '/'); idx != -1 {
+		token, next, hasNext = rest[:idx], rest[idx+1:], true
+	}
+
+	if n.prefixes != nil {
+		for i := 0; i <= len(token); i++ {
+			if strict && (i != len(token) || hasNext) {
+				continue
+			}
+			last := token[:i]
+			pRate := rate
+			if depth != 0 {
+				if last == "" {
+					pRate += starPathMatch
+				} else if last == token {
+					pRate += exactPathMatch
+				}
+			}
+			if pRate == noPathMatch {
+				continue
+			}
+			for _, entry := range n.prefixes[last] {
+				matches = append(matches, routeMatch{entry: entry, rate: pRate})
+			}
+		}
+	}
+
+	if child := n.children[token]; child != nil {
+		cRate := rate
+		if depth != 0 {
+			if token == "" {
+				cRate += starPathMatch
+			} else {
+				cRate += exactPathMatch
+			}
+		}
+		matches = child.collect(next, hasNext, strict, depth+1, cRate, matches)
+	}
+
+	if token != "" {
+		cRate := rate
+		if depth != 0 {
+			cRate += exactPathMatch
+		}
+		for _, child := range n.params {
+			if child.constraint != nil && !child.constraint.Match(token) {
+				continue
+			}
+			matches = child.node.collect(next, hasNext, strict, depth+1, cRate, matches)
+		}
+	}
+
+	return matches
+}
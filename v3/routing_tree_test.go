@@ -0,0 +1,174 @@
+package ucon
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// linearPickupBestRouteDefinition is the implementation before the tree was introduced.
+// It is kept for checking the compatibility and for the benchmark.
+func linearPickupBestRouteDefinition(ro *Router, r *http.Request) *RouteDefinition {
+	var bestRoute *RouteDefinition
+	var bestMethodMatchRate methodMatchRate
+	var bestPathMatchRate int
+
+	pathMatchRate := func(rd *RouteDefinition) int {
+		match, _ := rd.PathTemplate.Match(r.URL.Path)
+		if !match {
+			return noPathMatch
+		}
+
+		tempPathTokens := rd.PathTemplate.splittedPathTemplate
+		reqPathTokens := strings.Split(r.URL.Path, "/")
+
+		if len(reqPathTokens) < len(tempPathTokens) {
+			return noPathMatch
+		}
+
+		var rate int
+		for i, token := range tempPathTokens {
+			if i == 0 {
+				continue
+			}
+			if rd.PathTemplate.isVariables[i] {
+				rate += exactPathMatch
+				continue
+			}
+			if token == "" {
+				rate += starPathMatch
+				continue
+			}
+			if token == reqPathTokens[i] {
+				rate += exactPathMatch
+			}
+		}
+
+		return rate
+	}
+
+	for _, rd := range ro.handlers {
+		mRate := ro.methodMatchRate(rd, r)
+		if mRate == noMethodMatch {
+			continue
+		} else if mRate < bestMethodMatchRate {
+			continue
+		}
+
+		pRate := pathMatchRate(rd)
+		if pRate == noPathMatch {
+			continue
+		} else if pRate < bestPathMatchRate {
+			continue
+		}
+
+		if bestMethodMatchRate == mRate && bestPathMatchRate == pRate {
+			continue
+		}
+
+		bestMethodMatchRate = mRate
+		bestPathMatchRate = pRate
+		bestRoute = rd
+	}
+
+	return bestRoute
+}
+
+func TestRouteTreeCompatibility(t *testing.T) {
+	mux := NewServeMux()
+
+	h := func() {}
+
+	templates := []string{
+		"/", "/a", "/a/", "/a/b", "/a/{x}", "/a/{x}/", "/a/{x}/c", "/a/b/c",
+		"/api/todo", "/api/todo/", "/api/todo/{id}", "/api/todo/special", "/api/todo/{id}/comments/{cid}",
+		"/api/todo/nested/", "/api/todo/nested/too/long/", "/{x}", "/{x}/{y}", "/static/", "/static",
+		"/b{c", "", "/a//b",
+	}
+	methods := []string{"GET", "POST", "*", "OPTIONS"}
+	for i, tmpl := range templates {
+		mux.HandleFunc(methods[i%len(methods)], tmpl, h)
+		mux.HandleFunc(methods[(i+1)%len(methods)], tmpl, h)
+	}
+
+	paths := []string{
+		"/", "/a", "/a/", "/ab", "/a/b", "/a/bc", "/a/b/", "/a/b/c", "/a/b/c/d", "/a/x", "/a/x/", "/a/x/c",
+		"/api/todo", "/api/todos", "/api/todo/", "/api/todo/1", "/api/todo/special", "/api/todo/1/comments/2",
+		"/api/todo/nested/too", "/api/todo/nested/too/long/", "/static/js/index.js", "/staticx", "/b{c", "/a//b",
+		"//", "", "*", "/x/y/z",
+	}
+	for _, method := range []string{"GET", "HEAD", "POST", "PUT", "OPTIONS"} {
+		for _, path := range paths {
+			r := &http.Request{Method: method, URL: &url.URL{Path: path}}
+			expected := linearPickupBestRouteDefinition(mux.router, r)
+			actual := mux.router.pickupBestRouteDefinition(r)
+			if expected != actual {
+				t.Errorf("%s %s: expected %s, actual %s", method, path, describeRoute(expected), describeRoute(actual))
+			}
+		}
+	}
+}
+
+func describeRoute(rd *RouteDefinition) string {
+	if rd == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%s %s", rd.Method, rd.PathTemplate.PathTemplate)
+}
+
+func setupBenchmarkRouter(size int) *ServeMux {
+	mux := NewServeMux()
+
+	h := func() {}
+	for i := 0; i < size/4; i++ {
+		mux.HandleFunc("GET", fmt.Sprintf("/api/resource%d", i), h)
+		mux.HandleFunc("POST", fmt.Sprintf("/api/resource%d", i), h)
+		mux.HandleFunc("GET", fmt.Sprintf("/api/resource%d/{id}", i), h)
+		mux.HandleFunc("PUT", fmt.Sprintf("/api/resource%d/{id}/items/{itemID}", i), h)
+	}
+	mux.Prepare()
+
+	return mux
+}
+
+func benchmarkRequests(size int) []*http.Request {
+	var reqs []*http.Request
+	for _, path := range []string{
+		"/api/resource0",
+		fmt.Sprintf("/api/resource%d/1", size/8),
+		fmt.Sprintf("/api/resource%d/1/items/2", size/4-1),
+		"/api/unknown",
+	} {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			panic(err)
+		}
+		reqs = append(reqs, r)
+	}
+
+	return reqs
+}
+
+func BenchmarkRouterPickupBestRouteDefinition_tree(b *testing.B) {
+	mux := setupBenchmarkRouter(400)
+	reqs := benchmarkRequests(400)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mux.router.pickupBestRouteDefinition(reqs[i%len(reqs)])
+	}
+}
+
+func BenchmarkRouterPickupBestRouteDefinition_linear(b *testing.B) {
+	mux := setupBenchmarkRouter(400)
+	reqs := benchmarkRequests(400)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearPickupBestRouteDefinition(mux.router, reqs[i%len(reqs)])
+	}
+}
@@ -0,0 +1,205 @@
+package ucon
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrServerAlreadyStarted is the error that the server of ServeMux is already started.
+var ErrServerAlreadyStarted = errors.New("[ucon] server already started")
+
+// ErrServerNotStarted is the error that the server of ServeMux is not started.
+var ErrServerNotStarted = errors.New("[ucon] server not started")
+
+// ServerOption configures the http.Server which ServeMux starts.
+type ServerOption func(s *http.Server)
+
+// WithReadTimeout sets ReadTimeout of the http.Server.
+func WithReadTimeout(d time.Duration) ServerOption {
+	return func(s *http.Server) {
+		s.ReadTimeout = d
+	}
+}
+
+// WithReadHeaderTimeout sets ReadHeaderTimeout of the http.Server.
+func WithReadHeaderTimeout(d time.Duration) ServerOption {
+	return func(s *http.Server) {
+		s.ReadHeaderTimeout = d
+	}
+}
+
+// WithWriteTimeout sets WriteTimeout of the http.Server.
+func WithWriteTimeout(d time.Duration) ServerOption {
+	return func(s *http.Server) {
+		s.WriteTimeout = d
+	}
+}
+
+// WithIdleTimeout sets IdleTimeout of the http.Server.
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(s *http.Server) {
+		s.IdleTimeout = d
+	}
+}
+
+// ListenAndServe start accepts the client request.
+// It returns nil after the server is shut down gracefully by Shutdown.
+func (m *ServeMux) ListenAndServe(addr string, opts ...ServerOption) error {
+	s := m.newServer(addr, opts)
+	return m.serve(s, s.ListenAndServe)
+}
+
+// Serve accepts the client request on the listener.
+// It returns nil after the server is shut down gracefully by Shutdown.
+func (m *ServeMux) Serve(l net.Listener, opts ...ServerOption) error {
+	s := m.newServer(l.Addr().String(), opts)
+	return m.serve(s, func() error {
+		return s.Serve(l)
+	})
+}
+
+func (m *ServeMux) newServer(addr string, opts []ServerOption) *http.Server {
+	s := &http.Server{Addr: addr, Handler: m}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (m *ServeMux) serve(s *http.Server, serve func() error) error {
+	// claim the server first, not to prepare and run plugins for the server which never starts.
+	m.serverMu.Lock()
+	if m.server != nil {
+		m.serverMu.Unlock()
+		return ErrServerAlreadyStarted
+	}
+	done := make(chan struct{})
+	m.server = s
+	m.serverDone = done
+	m.serverMu.Unlock()
+
+	m.Prepare()
+
+	for _, plugin := range m.plugins {
+		if sp := plugin.ServerStart(); sp != nil {
+			err := sp.ServerStartProcess(m, s)
+			if err != nil {
+				m.releaseServer(s)
+				return err
+			}
+		}
+	}
+
+	err := serve()
+	if !m.releaseServer(s) && err == http.ErrServerClosed {
+		// shut down by Shutdown, wait for draining in-flight requests.
+		<-done
+		return nil
+	}
+
+	return err
+}
+
+// releaseServer releases the server if it is not taken by Shutdown, and reports whether it is released.
+func (m *ServeMux) releaseServer(s *http.Server) bool {
+	m.serverMu.Lock()
+	defer m.serverMu.Unlock()
+
+	if m.server != s {
+		return false
+	}
+	close(m.serverDone)
+	m.server = nil
+	m.serverDone = nil
+
+	return true
+}
+
+// Shutdown shuts down the server gracefully, then runs ServerShutdownPlugin.
+// In-flight requests are drained until the context is done.
+func (m *ServeMux) Shutdown(c context.Context) error {
+	m.serverMu.Lock()
+	s, done := m.server, m.serverDone
+	m.server = nil
+	m.serverDone = nil
+	m.serverMu.Unlock()
+
+	if s == nil {
+		return ErrServerNotStarted
+	}
+	defer close(done)
+
+	err := s.Shutdown(c)
+
+	for _, plugin := range m.plugins {
+		if sp := plugin.ServerShutdown(); sp != nil {
+			pErr := sp.ServerShutdownProcess(c, m)
+			if pErr != nil && err == nil {
+				err = pErr
+			}
+		}
+	}
+
+	return err
+}
+
+// ShutdownOnSignal shuts down the server by Shutdown when the process receives one of the signals.
+// If no signal is given, os.Interrupt and SIGTERM are used.
+// The timeout is the limit for draining in-flight requests.
+// It returns a function to stop waiting for the signals.
+func (m *ServeMux) ShutdownOnSignal(timeout time.Duration, signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, signals...)
+
+	return m.shutdownOnSignal(timeout, sigCh, func() {
+		signal.Stop(sigCh)
+	})
+}
+
+// shutdownOnSignal shuts down the server when sigCh receives a signal, and calls release after that or stop.
+func (m *ServeMux) shutdownOnSignal(timeout time.Duration, sigCh <-chan os.Signal, release func()) (stop func()) {
+	stopCh := make(chan struct{})
+	go func() {
+		defer release()
+
+		select {
+		case <-sigCh:
+			c, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := m.Shutdown(c); err != nil {
+				log.Printf("[ucon] shutdown: %s", err.Error())
+			}
+		case <-stopCh:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopCh)
+		})
+	}
+}
+
+// Shutdown shuts down the server of DefaultMux gracefully.
+func Shutdown(c context.Context) error {
+	return DefaultMux.Shutdown(c)
+}
+
+// ShutdownOnSignal shuts down the server of DefaultMux when the process receives one of the signals.
+func ShutdownOnSignal(timeout time.Duration, signals ...os.Signal) (stop func()) {
+	return DefaultMux.ShutdownOnSignal(timeout, signals...)
+}
@@ -0,0 +1,255 @@
+package ucon
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TargetOfServerLifecyclePlugin struct {
+	mu     sync.Mutex
+	called []string
+}
+
+func (p *TargetOfServerLifecyclePlugin) ServerStartProcess(m *ServeMux, s *http.Server) error {
+	p.record("start")
+	if v := s.ReadTimeout; v != 3*time.Second {
+		return ErrInvalidArgumentValue
+	}
+	return nil
+}
+
+func (p *TargetOfServerLifecyclePlugin) ServerShutdownProcess(c context.Context, m *ServeMux) error {
+	p.record("shutdown")
+	return nil
+}
+
+func (p *TargetOfServerLifecyclePlugin) record(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.called = append(p.called, name)
+}
+
+func (p *TargetOfServerLifecyclePlugin) calledString() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return strings.Join(p.called, ",")
+}
+
+func TestServeMuxShutdown(t *testing.T) {
+	mux := NewServeMux()
+	plugin := &TargetOfServerLifecyclePlugin{}
+	mux.Plugin(plugin)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux.Middleware(HTTPRWDI())
+	mux.HandleFunc("GET", "/slow", func(w http.ResponseWriter, r *http.Request) {
+		plugin.record("request")
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- mux.Serve(l, WithReadTimeout(3*time.Second))
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responded := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/slow")
+		if err != nil {
+			responded <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		responded <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- mux.Shutdown(context.Background())
+	}()
+
+	// the in-flight request is drained
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-served:
+		t.Fatalf("unexpected: %v", err)
+	default:
+	}
+	close(release)
+
+	if r := <-responded; r.err != nil || r.body != "done" {
+		t.Errorf("unexpected: %v %v", r.body, r.err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("unexpected: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("unexpected: %v", err)
+	}
+	if v := plugin.calledString(); v != "start,request,shutdown" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	if err := mux.Shutdown(context.Background()); err != ErrServerNotStarted {
+		t.Errorf("unexpected: %v", err)
+	}
+}
+
+func TestServeMuxServe_startPluginError(t *testing.T) {
+	mux := NewServeMux()
+	mux.Plugin(&TargetOfServerLifecyclePlugin{})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if err := mux.Serve(l); err != ErrInvalidArgumentValue {
+		t.Errorf("unexpected: %v", err)
+	}
+}
+
+func TestServeMuxShutdownOnSignal(t *testing.T) {
+	mux := NewServeMux()
+	plugin := &TargetOfServerLifecyclePlugin{}
+	mux.Plugin(plugin)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- mux.Serve(l, WithReadTimeout(3*time.Second))
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	released := make(chan struct{})
+	stop := mux.shutdownOnSignal(time.Second, sigCh, func() {
+		close(released)
+	})
+	defer stop()
+
+	// wait for the server to start
+	for i := 0; i < 100; i++ {
+		if plugin.calledString() == "start" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sigCh <- os.Interrupt
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("unexpected: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("server is not shut down")
+	}
+	<-released
+	if v := plugin.calledString(); v != "start,shutdown" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestServeMuxShutdownOnSignal_stop(t *testing.T) {
+	mux := NewServeMux()
+
+	released := make(chan struct{})
+	stop := mux.shutdownOnSignal(time.Second, nil, func() {
+		close(released)
+	})
+	stop()
+	stop()
+
+	select {
+	case <-released:
+	case <-time.After(3 * time.Second):
+		t.Fatal("signals are not released")
+	}
+}
+
+type TargetOfServerClosePlugin struct {
+	scanned   int
+	started   int
+	startedCh chan struct{}
+	closeCh   chan struct{}
+}
+
+func (p *TargetOfServerClosePlugin) HandlersScannerProcess(m *ServeMux, rds []*RouteDefinition) error {
+	p.scanned++
+	return nil
+}
+
+func (p *TargetOfServerClosePlugin) ServerStartProcess(m *ServeMux, s *http.Server) error {
+	p.started++
+	close(p.startedCh)
+	// closed by others than ServeMux.Shutdown
+	go func() {
+		<-p.closeCh
+		s.Close()
+	}()
+	return nil
+}
+
+func TestServeMuxServe_alreadyStarted(t *testing.T) {
+	mux := NewServeMux()
+	plugin := &TargetOfServerClosePlugin{startedCh: make(chan struct{}), closeCh: make(chan struct{})}
+	mux.Plugin(plugin)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- mux.Serve(l)
+	}()
+	<-plugin.startedCh
+
+	if err := mux.ListenAndServe("127.0.0.1:0"); err != ErrServerAlreadyStarted {
+		t.Errorf("unexpected: %v", err)
+	}
+	close(plugin.closeCh)
+
+	select {
+	case err := <-served:
+		if err != http.ErrServerClosed {
+			t.Errorf("unexpected: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("server is not closed")
+	}
+	if plugin.scanned != 1 || plugin.started != 1 {
+		t.Errorf("unexpected: %v %v", plugin.scanned, plugin.started)
+	}
+	if err := mux.Shutdown(context.Background()); err != ErrServerNotStarted {
+		t.Errorf("unexpected: %v", err)
+	}
+}
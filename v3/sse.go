@@ -0,0 +1,144 @@
+package ucon
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// DefaultHeartbeatInterval is the default of ServeMux.HeartbeatInterval.
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// Event is a message of Server-Sent Events.
+type Event struct {
+	// ID is sent as `id` field, browsers send it back by Last-Event-ID header on reconnection.
+	ID string
+	// Event is sent as `event` field, the type of the event.
+	Event string
+	// Data is sent as `data` field. string and []byte are sent as is, and others are encoded as JSON.
+	Data interface{}
+	// Retry is sent as `retry` field, the reconnection time of browsers.
+	Retry time.Duration
+}
+
+// EventStream is a return value of handlers to send Server-Sent Events.
+// Events are sent until the channel is closed or Bubble.Context is done, `<-chan Event` is the same.
+// e.g. `func(c context.Context, lastEventID ucon.LastEventID) (ucon.EventStream, error)`
+type EventStream <-chan Event
+
+// LastEventID is a handler argument which has Last-Event-ID header of the request, to resume Server-Sent Events.
+type LastEventID string
+
+var eventType = reflect.TypeOf(Event{})
+var lastEventIDType = reflect.TypeOf(LastEventID(""))
+
+// isEventStream reports whether the return value is a receivable channel of Event.
+func isEventStream(rv reflect.Value) bool {
+	return rv.Kind() == reflect.Chan && rv.Type().ChanDir()&reflect.RecvDir != 0 && rv.Type().Elem() == eventType
+}
+
+// writeEvents sends events of the channel as Server-Sent Events.
+// It sends a comment line every ServeMux.HeartbeatInterval to keep the connection alive.
+func (b *Bubble) writeEvents(ch reflect.Value, status int) error {
+	codec := b.mux.codecOf("application/json")
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+
+	b.W.Header().Set("Content-Type", "text/event-stream")
+	b.W.Header().Set("Cache-Control", "no-cache")
+	b.W.WriteHeader(status)
+	b.flush()
+
+	interval := DefaultHeartbeatInterval
+	if b.mux != nil && b.mux.HeartbeatInterval != 0 {
+		interval = b.mux.HeartbeatInterval
+	}
+	var heartbeat <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(b.Context.Done())},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(heartbeat)},
+	}
+	for {
+		if b.Context.Err() != nil {
+			return nil
+		}
+
+		var data []byte
+		chosen, v, ok := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			if !ok {
+				return nil
+			}
+			var err error
+			data, err = encodeEvent(v.Interface().(Event), codec)
+			if err != nil {
+				return b.streamError(err)
+			}
+		case 1:
+			// the sender should stop by the context too
+			return nil
+		case 2:
+			data = []byte(":\n\n")
+		}
+
+		_, err := b.W.Write(data)
+		if err != nil {
+			return b.streamError(err)
+		}
+		b.flush()
+	}
+}
+
+func encodeEvent(e Event, codec Codec) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if e.ID != "" {
+		fmt.Fprintf(buf, "id: %s\n", sanitizeEventField(e.ID))
+	}
+	if e.Event != "" {
+		fmt.Fprintf(buf, "event: %s\n", sanitizeEventField(e.Event))
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(buf, "retry: %d\n", e.Retry/time.Millisecond)
+	}
+
+	var data string
+	switch v := e.Data.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		b, err := codec.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data = string(b)
+	}
+	// a bare CR is also a line break of the stream, so it must start a new data field too.
+	data = eventLineBreaks.Replace(data)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+
+	return buf.Bytes(), nil
+}
+
+var eventLineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// sanitizeEventField removes line breaks which terminate the field.
+func sanitizeEventField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
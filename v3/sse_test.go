@@ -0,0 +1,123 @@
+package ucon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeEvent(t *testing.T) {
+	data, err := encodeEvent(Event{ID: "1\n", Event: "todo", Data: "line1\r\nline2", Retry: 3 * time.Second}, JSONCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(data); v != "id: 1\nevent: todo\nretry: 3000\ndata: line1\ndata: line2\n\n" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	data, err = encodeEvent(Event{Data: &ItemOfStream{ID: 1}}, JSONCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(data); v != "data: {\"id\":1}\n\n" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	// a bare CR must not inject fields
+	data, err = encodeEvent(Event{Data: []byte("a\rid: evil\r\n\nb")}, JSONCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(data); v != "data: a\ndata: id: evil\ndata: \ndata: b\n\n" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func handlerOfEventsWait(c context.Context) (<-chan Event, error) {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		ch <- Event{Data: "ready"}
+		<-c.Done()
+	}()
+	return ch, nil
+}
+
+func TestResponseMapper_withEventStream(t *testing.T) {
+	DefaultMux = NewServeMux()
+	DefaultMux.HeartbeatInterval = -1
+	Orthodox()
+
+	HandleFunc("GET", "/api/events", func(c context.Context, lastEventID LastEventID) (EventStream, error) {
+		ch := make(chan Event)
+		go func() {
+			defer close(ch)
+			ch <- Event{ID: "2", Event: "resume", Data: string(lastEventID)}
+			ch <- Event{ID: "3", Data: &ItemOfStream{ID: 3}}
+		}()
+		return ch, nil
+	})
+
+	DefaultMux.Prepare()
+
+	r := httptest.NewRequest("GET", "/api/events", nil)
+	r.Header.Set("Last-Event-ID", "1")
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := w.Header().Get("Content-Type"); v != "text/event-stream" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Header().Get("Cache-Control"); v != "no-cache" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Body.String(); v != "id: 2\nevent: resume\ndata: 1\n\nid: 3\ndata: {\"id\":3}\n\n" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestResponseMapper_withEventStreamCanceled(t *testing.T) {
+	DefaultMux = NewServeMux()
+	DefaultMux.HeartbeatInterval = -1
+	Orthodox()
+
+	HandleFunc("GET", "/api/events/wait", handlerOfEventsWait)
+
+	DefaultMux.Prepare()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/api/events/wait", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	DefaultMux.ServeHTTP(w, r)
+
+	if v := w.Body.String(); v != "data: ready\n\n" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestResponseMapper_withEventStreamHeartbeat(t *testing.T) {
+	DefaultMux = NewServeMux()
+	DefaultMux.HeartbeatInterval = 10 * time.Millisecond
+	Orthodox()
+
+	HandleFunc("GET", "/api/events/wait", handlerOfEventsWait)
+
+	DefaultMux.Prepare()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("GET", "/api/events/wait", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if v := w.Body.String(); !strings.HasPrefix(v, "data: ready\n\n:\n\n") {
+		t.Errorf("unexpected: %v", v)
+	}
+}
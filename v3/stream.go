@@ -0,0 +1,163 @@
+package ucon
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// NDJSONMediaType is the media type of newline delimited JSON.
+// Items of a channel returned by handlers are written as NDJSON if the request accepts it, otherwise as a JSON array.
+const NDJSONMediaType = "application/x-ndjson"
+
+const streamBufferSize = 32 * 1024
+
+// isStream reports whether the return value is written by streaming, io.Reader or a receivable channel.
+func isStream(rv reflect.Value) bool {
+	if isNil(rv) {
+		return false
+	}
+	if _, ok := rv.Interface().(io.Reader); ok {
+		return true
+	}
+	return rv.Kind() == reflect.Chan && rv.Type().ChanDir()&reflect.RecvDir != 0
+}
+
+// writeStream writes io.Reader or items of the channel to the response, and flushes each chunk.
+// It stops when Bubble.Context is done, e.g. the client has gone away.
+// Errors after the response header is sent are not returned, see streamError.
+func (b *Bubble) writeStream(rv reflect.Value, status int) error {
+	if r, ok := rv.Interface().(io.Reader); ok {
+		return b.writeReader(r, status)
+	}
+	return b.writeChannel(rv, status)
+}
+
+func (b *Bubble) writeReader(r io.Reader, status int) error {
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if b.W.Header().Get("Content-Type") == "" {
+		b.W.Header().Set("Content-Type", "application/octet-stream")
+	}
+	b.W.WriteHeader(status)
+
+	buf := make([]byte, streamBufferSize)
+	for {
+		if b.Context.Err() != nil {
+			return nil
+		}
+
+		n, err := r.Read(buf)
+		if n > 0 {
+			_, werr := b.W.Write(buf[:n])
+			if werr != nil {
+				return b.streamError(werr)
+			}
+			b.flush()
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return b.streamError(err)
+		}
+	}
+}
+
+func (b *Bubble) writeChannel(ch reflect.Value, status int) error {
+	codec := b.mux.codecOf("application/json")
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+
+	ndjson := acceptsMediaType(b.R.Header.Get("Accept"), NDJSONMediaType)
+	if ndjson {
+		b.W.Header().Set("Content-Type", NDJSONMediaType)
+	} else {
+		b.W.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	}
+	b.W.WriteHeader(status)
+	if !ndjson {
+		b.W.Write([]byte("["))
+	}
+
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(b.Context.Done())},
+	}
+	for first := true; ; first = false {
+		if b.Context.Err() != nil {
+			return nil
+		}
+		chosen, item, ok := reflect.Select(cases)
+		if chosen == 1 {
+			// the sender should stop by the context too
+			return nil
+		}
+		if !ok {
+			break
+		}
+
+		data, err := codec.Marshal(item.Interface())
+		if err != nil {
+			return b.streamError(err)
+		}
+		if ndjson {
+			data = append(data, '\n')
+		} else if !first {
+			data = append([]byte(","), data...)
+		}
+		_, err = b.W.Write(data)
+		if err != nil {
+			return b.streamError(err)
+		}
+		b.flush()
+	}
+
+	if !ndjson {
+		b.W.Write([]byte("]"))
+	}
+
+	return nil
+}
+
+// streamError reports the error which occurred after the response header is sent.
+// The error can not be written to the partially sent response, so it is passed to plugins which hook errors and logged,
+// and nil is returned not to be handled by ServeMux.
+func (b *Bubble) streamError(err error) error {
+	if b.mux != nil {
+		for _, plugin := range b.mux.plugins {
+			if p := plugin.RequestError(); p != nil {
+				p.RequestErrorProcess(b, err)
+			}
+		}
+	}
+	log.Printf("[ucon] streaming response: %s", err.Error())
+
+	return nil
+}
+
+func (b *Bubble) flush() {
+	if f, ok := b.W.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// acceptsMediaType reports whether Accept header has the media type explicitly.
+func acceptsMediaType(accept string, mediaType string) bool {
+	for _, text := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(text)
+		if err != nil || mt != mediaType {
+			continue
+		}
+		q, err := strconv.ParseFloat(params["q"], 64)
+		return err != nil || q > 0
+	}
+
+	return false
+}
@@ -0,0 +1,168 @@
+package ucon
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ItemOfStream struct {
+	ID int `json:"id"`
+}
+
+func handlerOfStreamItems(c context.Context) (<-chan *ItemOfStream, error) {
+	ch := make(chan *ItemOfStream)
+	go func() {
+		defer close(ch)
+		for i := 1; i <= 3; i++ {
+			select {
+			case ch <- &ItemOfStream{ID: i}:
+			case <-c.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func TestResponseMapper_withReader(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("GET", "/api/export", func() (*Response, error) {
+		resp := NewResponse(http.StatusOK, ioutil.NopCloser(strings.NewReader("id,text\n1,Hi!\n")))
+		resp.Header.Set("Content-Type", "text/csv")
+		return resp, nil
+	})
+	HandleFunc("GET", "/api/raw", func() (*strings.Reader, error) {
+		return strings.NewReader("raw"), nil
+	})
+
+	DefaultMux.Prepare()
+
+	r := httptest.NewRequest("GET", "/api/export", nil)
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := w.Header().Get("Content-Type"); v != "text/csv" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Body.String(); v != "id,text\n1,Hi!\n" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if !w.Flushed {
+		t.Errorf("unexpected: %v", w.Flushed)
+	}
+
+	r = httptest.NewRequest("GET", "/api/raw", nil)
+	w = httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if v := w.Header().Get("Content-Type"); v != "application/octet-stream" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Body.String(); v != "raw" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestResponseMapper_withChannel(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("GET", "/api/items", handlerOfStreamItems)
+
+	DefaultMux.Prepare()
+
+	r := httptest.NewRequest("GET", "/api/items", nil)
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if v := w.Header().Get("Content-Type"); v != "application/json; charset=UTF-8" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Body.String(); v != `[{"id":1},{"id":2},{"id":3}]` {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	r = httptest.NewRequest("GET", "/api/items", nil)
+	r.Header.Set("Accept", NDJSONMediaType)
+	w = httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if v := w.Header().Get("Content-Type"); v != NDJSONMediaType {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := w.Body.String(); v != "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestResponseMapper_withChannelCanceled(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("GET", "/api/items", handlerOfStreamItems)
+
+	DefaultMux.Prepare()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("GET", "/api/items", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if v := w.Body.String(); v != "[" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+// brokenReaderOfStream returns an error after the data.
+type brokenReaderOfStream struct {
+	data string
+}
+
+func (r *brokenReaderOfStream) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("broken")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestResponseMapper_withBrokenReader(t *testing.T) {
+	DefaultMux = NewServeMux()
+	plugin := &TargetOfLifecyclePlugin{}
+	Plugin(plugin)
+	Orthodox()
+
+	HandleFunc("GET", "/api/export", func() (io.Reader, error) {
+		return &brokenReaderOfStream{data: "id,text\n"}, nil
+	})
+
+	DefaultMux.Prepare()
+
+	r := httptest.NewRequest("GET", "/api/export", nil)
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	// the error object is not appended to the partial body
+	if v := w.Body.String(); v != "id,text\n" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := strings.Join(plugin.called, ","); v != "start,middleware,error:broken,end" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
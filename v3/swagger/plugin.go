@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"sort"
@@ -17,6 +18,7 @@ import (
 var _ ucon.HandlersScannerPlugin = &Plugin{}
 var _ ucon.Context = &HandlerInfo{}
 var _ ucon.HandlerContainer = &HandlerInfo{}
+var _ ucon.MiddlewareContainer = &HandlerInfo{}
 
 type swaggerOperationKey struct{}
 
@@ -25,6 +27,14 @@ var httpRespType = reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
 var netContextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 var errorType = reflect.TypeOf((*error)(nil)).Elem()
 var uconHTTPErrorType = reflect.TypeOf((*ucon.HTTPErrorResponse)(nil)).Elem()
+var uconResponseType = reflect.TypeOf((*ucon.Response)(nil))
+var uconStatusCodeType = reflect.TypeOf(ucon.StatusCode(0))
+var uconEventType = reflect.TypeOf(ucon.Event{})
+var uconLastEventIDType = reflect.TypeOf(ucon.LastEventID(""))
+var uconWSConnType = reflect.TypeOf((*ucon.WSConn)(nil)).Elem()
+var ioReaderType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+var uconFileType = reflect.TypeOf((*ucon.File)(nil))
+var uconFilesType = reflect.TypeOf([]*ucon.File(nil))
 
 // DefaultTypeSchemaMapper is used for mapping from go-type to swagger-schema.
 var DefaultTypeSchemaMapper = map[reflect.Type]*TypeSchema{
@@ -123,9 +133,10 @@ type parameterWrapper struct {
 }
 
 // HandlerInfo is a container of the handler function and the operation with the context.
-// HandlerInfo implements interfaces of ucon.HandlerContainer and ucon.Context.
+// HandlerInfo implements interfaces of ucon.HandlerContainer, ucon.MiddlewareContainer and ucon.Context.
 type HandlerInfo struct {
-	HandlerFunc interface{}
+	HandlerFunc     interface{}
+	MiddlewareFuncs []ucon.MiddlewareFunc
 	Operation
 	Context ucon.Context
 }
@@ -195,7 +206,8 @@ func (p *Plugin) HandlersScannerProcess(m *ucon.ServeMux, rds []*ucon.RouteDefin
 }
 
 func (soConstructor *swaggerObjectConstructor) processHandler(rd *ucon.RouteDefinition) error {
-	item := soConstructor.object.Paths[rd.PathTemplate.PathTemplate]
+	path := rd.PathTemplate.PathWithoutConstraints()
+	item := soConstructor.object.Paths[path]
 	if item == nil {
 		item = &PathItem{}
 	}
@@ -203,6 +215,10 @@ func (soConstructor *swaggerObjectConstructor) processHandler(rd *ucon.RouteDefi
 	if soConstructor.plugin.options.IgnoreRoute != nil && soConstructor.plugin.options.IgnoreRoute(rd) {
 		return nil
 	}
+	if rd.Mounted() {
+		// mounted http.Handler has no information for swagger
+		return nil
+	}
 
 	var setOperation func(op *Operation)
 	switch rd.Method {
@@ -246,7 +262,7 @@ func (soConstructor *swaggerObjectConstructor) processHandler(rd *ucon.RouteDefi
 		return err
 	} else if op != nil {
 		setOperation(op)
-		soConstructor.object.Paths[rd.PathTemplate.PathTemplate] = item
+		soConstructor.object.Paths[path] = item
 
 		err := soConstructor.execFinisher()
 		if err != nil {
@@ -275,13 +291,17 @@ func (soConstructor *swaggerObjectConstructor) extractSwaggerOperation(rd *ucon.
 	op, ok := rd.HandlerContainer.Value(swaggerOperationKey{}).(*Operation)
 	if !ok || op == nil {
 		op = &Operation{
-			Description: fmt.Sprintf("%s %s", rd.Method, rd.PathTemplate.PathTemplate),
+			Description: fmt.Sprintf("%s %s", rd.Method, rd.PathTemplate.PathWithoutConstraints()),
 		}
 	}
+	status := http.StatusOK
+	if rd.Status != 0 {
+		status = rd.Status
+	}
 	if len(op.Responses) == 0 {
 		op.Responses = make(Responses, 0)
-		op.Responses["200"] = &Response{
-			Description: fmt.Sprintf("response of %s %s", rd.Method, rd.PathTemplate.PathTemplate),
+		op.Responses[strconv.Itoa(status)] = &Response{
+			Description: fmt.Sprintf("response of %s %s", rd.Method, rd.PathTemplate.PathWithoutConstraints()),
 		}
 	}
 
@@ -295,6 +315,10 @@ func (soConstructor *swaggerObjectConstructor) extractSwaggerOperation(rd *ucon.
 			continue
 		} else if arg == netContextType {
 			continue
+		} else if arg == uconLastEventIDType {
+			continue
+		} else if arg == uconWSConnType {
+			continue
 		}
 		reqType = arg
 		break
@@ -304,6 +328,8 @@ func (soConstructor *swaggerObjectConstructor) extractSwaggerOperation(rd *ucon.
 		if ret.AssignableTo(errorType) {
 			errType = ret
 			continue
+		} else if ret == uconStatusCodeType {
+			continue
 		}
 		respType = ret
 	}
@@ -322,25 +348,15 @@ func (soConstructor *swaggerObjectConstructor) extractSwaggerOperation(rd *ucon.
 		sort.Strings(paramNames)
 
 		needBody := false
+		var formParamNames []string
+		hasFile := false
 	outer:
 		for _, paramName := range paramNames {
 			pw := paramMap[paramName]
 
 			// in path
 			if pw.InPath() {
-				op.Parameters = append(op.Parameters, &Parameter{
-					Name:      paramName,
-					In:        "path",
-					Required:  true,
-					Type:      pw.ParameterType(),
-					Format:    pw.ParameterFormat(),
-					Enum:      pw.ParameterEnum(),
-					Minimum:   pw.Minimum(),
-					Maximum:   pw.Maximum(),
-					MinLength: pw.MinLength(),
-					MaxLength: pw.MaxLength(),
-					Pattern:   pw.Pattern(),
-				})
+				op.Parameters = append(op.Parameters, newPathParameter(rd, paramName, pw))
 
 				continue
 			} else {
@@ -348,28 +364,21 @@ func (soConstructor *swaggerObjectConstructor) extractSwaggerOperation(rd *ucon.
 					if paramName != pathParam {
 						continue
 					}
-					op.Parameters = append(op.Parameters, &Parameter{
-						Name:      paramName,
-						In:        "path",
-						Required:  true,
-						Type:      pw.ParameterType(),
-						Format:    pw.ParameterFormat(),
-						Enum:      pw.ParameterEnum(),
-						Minimum:   pw.Minimum(),
-						Maximum:   pw.Maximum(),
-						MinLength: pw.MinLength(),
-						MaxLength: pw.MaxLength(),
-						Pattern:   pw.Pattern(),
-					})
+					op.Parameters = append(op.Parameters, newPathParameter(rd, paramName, pw))
 					continue outer
 				}
 			}
 
-			// in query
-			if pw.InQuery() {
+			// NOTE Swagger 2.0 has no cookie parameters.
+			if pw.InCookie() {
+				continue
+			}
+
+			// in query or header
+			if pw.InQuery() || pw.InHeader() {
 				param := &Parameter{
 					Name:      pw.Name(),
-					In:        "query",
+					In:        NewTagSwagger(pw.StructField.Tag).In(),
 					Required:  pw.Required(),
 					Type:      pw.ParameterType(),
 					Format:    pw.ParameterFormat(),
@@ -415,9 +424,43 @@ func (soConstructor *swaggerObjectConstructor) extractSwaggerOperation(rd *ucon.
 				continue
 			}
 
+			if pw.IsFile() {
+				hasFile = true
+			}
+			formParamNames = append(formParamNames, paramName)
 			needBody = true
 		}
 
+		// in formData, files can not be in body
+		if hasFile {
+			for _, paramName := range formParamNames {
+				pw := paramMap[paramName]
+				param := &Parameter{
+					Name:      pw.Name(),
+					In:        "formData",
+					Required:  pw.Required(),
+					Type:      pw.ParameterType(),
+					Format:    pw.ParameterFormat(),
+					Enum:      pw.ParameterEnum(),
+					Minimum:   pw.Minimum(),
+					Maximum:   pw.Maximum(),
+					MinLength: pw.MinLength(),
+					MaxLength: pw.MaxLength(),
+					Pattern:   pw.Pattern(),
+				}
+				if pw.IsFile() {
+					// NOTE Swagger 2.0 has no array of files.
+					param.Type = "file"
+					param.Format = ""
+				}
+				op.Parameters = append(op.Parameters, param)
+			}
+			if len(op.Consumes) == 0 {
+				op.Consumes = []string{"multipart/form-data"}
+			}
+			needBody = false
+		}
+
 		// in body
 		if needBody {
 			bodyParameter = &Parameter{
@@ -453,6 +496,29 @@ func (soConstructor *swaggerObjectConstructor) extractSwaggerOperation(rd *ucon.
 		}
 	}
 
+	// the body of ucon.Response is unknown, and some status codes have no body
+	if respType == uconResponseType || status == http.StatusNoContent || status == http.StatusNotModified {
+		respType = nil
+	}
+	if respType != nil && respType.Implements(ioReaderType) {
+		// streamed as is
+		respType = nil
+		for _, resp := range op.Responses {
+			resp.Schema = &Schema{Type: "file"}
+		}
+	} else if respType != nil && respType.Kind() == reflect.Chan && respType.Elem() == uconEventType {
+		// Server-Sent Events
+		respType = nil
+		for _, resp := range op.Responses {
+			resp.Schema = &Schema{Type: "string"}
+		}
+		if len(op.Produces) == 0 {
+			op.Produces = []string{"text/event-stream"}
+		}
+	} else if respType != nil && respType.Kind() == reflect.Chan {
+		// streamed as a JSON array or NDJSON
+		respType = reflect.SliceOf(respType.Elem())
+	}
 	if respType != nil {
 		ts, err := soConstructor.extractTypeSchema(respType)
 		if err != nil {
@@ -505,6 +571,41 @@ func (soConstructor *swaggerObjectConstructor) extractSwaggerOperation(rd *ucon.
 	return op, nil
 }
 
+func newPathParameter(rd *ucon.RouteDefinition, paramName string, pw *parameterWrapper) *Parameter {
+	param := &Parameter{
+		Name:      paramName,
+		In:        "path",
+		Required:  true,
+		Type:      pw.ParameterType(),
+		Format:    pw.ParameterFormat(),
+		Enum:      pw.ParameterEnum(),
+		Minimum:   pw.Minimum(),
+		Maximum:   pw.Maximum(),
+		MinLength: pw.MinLength(),
+		MaxLength: pw.MaxLength(),
+		Pattern:   pw.Pattern(),
+	}
+
+	// constraint in path template, e.g. {id:int}
+	if c := rd.PathTemplate.PathConstraints[paramName]; c != nil {
+		switch c.Type {
+		case ucon.PathConstraintInt:
+			if param.Type != "integer" {
+				param.Type = "integer"
+				param.Format = "int64"
+			}
+		case ucon.PathConstraintUUID:
+			param.Type = "string"
+			param.Format = "uuid"
+		case ucon.PathConstraintRegexp:
+			param.Type = "string"
+			param.Pattern = c.Pattern
+		}
+	}
+
+	return param
+}
+
 func (soConstructor *swaggerObjectConstructor) extractFieldInfo(sf reflect.StructField) (*FieldInfo, error) {
 	fiInfo := &FieldInfo{Base: sf}
 
@@ -867,6 +968,21 @@ func (pw *parameterWrapper) InQuery() bool {
 	return swaggerTag.In() == "query"
 }
 
+func (pw *parameterWrapper) InHeader() bool {
+	swaggerTag := NewTagSwagger(pw.StructField.Tag)
+	return swaggerTag.In() == "header"
+}
+
+func (pw *parameterWrapper) InCookie() bool {
+	swaggerTag := NewTagSwagger(pw.StructField.Tag)
+	return swaggerTag.In() == "cookie"
+}
+
+func (pw *parameterWrapper) IsFile() bool {
+	refT := pw.StructField.Type
+	return refT == uconFileType || refT == uconFilesType
+}
+
 func (pw *parameterWrapper) Name() string {
 	swaggerTag := NewTagSwagger(pw.StructField.Tag)
 	name := swaggerTag.Name()
@@ -945,6 +1061,11 @@ func (wr *HandlerInfo) Handler() interface{} {
 	return wr.HandlerFunc
 }
 
+// Middlewares returns middlewares which run only for the handler function.
+func (wr *HandlerInfo) Middlewares() []ucon.MiddlewareFunc {
+	return wr.MiddlewareFuncs
+}
+
 // Value returns the value contained with the key.
 func (wr *HandlerInfo) Value(key interface{}) interface{} {
 	if key == (swaggerOperationKey{}) {
@@ -3,6 +3,9 @@ package swagger
 import (
 	"context"
 	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
@@ -759,4 +762,337 @@ func TestSwaggerObjectConstructorProcessHandler_withIgnoreRoute_match(t *testing
 	if v := len(swObj.Paths); v != 0 {
 		t.Fatalf("unexpected: %v", v)
 	}
-}
\ No newline at end of file
+}
+func TestHandlerInfoMiddlewares(t *testing.T) {
+	mux := ucon.NewServeMux()
+	mux.Middleware(ucon.ResponseMapper())
+	mux.Plugin(NewPlugin(&Options{Object: &Object{Info: &Info{Title: "test", Version: "test"}}}))
+
+	called := false
+	hi := NewHandlerInfo(func() (*Resp, error) {
+		return &Resp{}, nil
+	})
+	hi.MiddlewareFuncs = append(hi.MiddlewareFuncs, func(b *ucon.Bubble) error {
+		called = true
+		return b.Next()
+	})
+	mux.Handle("GET", "/api/test", hi)
+
+	mux.Prepare()
+
+	r := httptest.NewRequest("GET", "/api/test", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	if v := w.Code; v != 200 {
+		t.Errorf("unexpected: %v", v)
+	}
+	if !called {
+		t.Errorf("unexpected: %v", called)
+	}
+}
+
+type ReqSwaggerConstrainedParameter struct {
+	ID   string `json:"id"`
+	UUID string `json:"uuid"`
+	Slug string `json:"slug"`
+}
+
+func TestSwaggerObjectConstructorProcessHandler_withPathConstraints(t *testing.T) {
+	p := NewPlugin(nil)
+
+	rd := &ucon.RouteDefinition{
+		Method:       "GET",
+		PathTemplate: ucon.ParsePathTemplate("/api/test/{id:int}/{uuid:uuid}/{slug:[a-z-]+}"),
+		HandlerContainer: &handlerContainerImpl{
+			handler: func(c context.Context, req *ReqSwaggerConstrainedParameter) (*Resp, error) {
+				return nil, nil
+			},
+		},
+	}
+
+	err := p.constructor.processHandler(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := p.constructor.object.Paths["/api/test/{id}/{uuid}/{slug}"].Get
+	if v := len(op.Parameters); v != 3 {
+		t.Fatalf("unexpected: %v", v)
+	}
+
+	params := make(map[string]*Parameter)
+	for _, param := range op.Parameters {
+		if param.In != "path" {
+			t.Errorf("unexpected: %v", param.In)
+		}
+		params[param.Name] = param
+	}
+	if v := params["id"]; v.Type != "integer" || v.Format != "int64" || v.Pattern != "" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := params["uuid"]; v.Type != "string" || v.Format != "uuid" || v.Pattern != "" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := params["slug"]; v.Type != "string" || v.Format != "" || v.Pattern != "[a-z-]+" {
+		t.Errorf("unexpected: %#v", v)
+	}
+}
+
+func TestSwaggerObjectConstructorProcessHandler_withMountedHandler(t *testing.T) {
+	p := NewPlugin(&Options{
+		Object: &Object{
+			Info: &Info{
+				Title:   "test",
+				Version: "v1",
+			},
+		},
+	})
+
+	mux := ucon.NewServeMux()
+	mux.Plugin(p)
+	mux.HandleFunc("GET", "/api/test", func(c context.Context) (*Resp, error) {
+		return nil, nil
+	})
+	mux.Mount("/debug/pprof", http.NotFoundHandler())
+	mux.Prepare()
+
+	swObj := p.constructor.object
+
+	if v := len(swObj.Paths); v != 1 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := swObj.Paths["/api/test"]; v == nil {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+type ReqSwaggerHeaderParameter struct {
+	ID        int64  `json:"id"`
+	RequestID string `json:"requestID" swagger:"X-Request-Id,in=header"`
+	Session   string `json:"session" swagger:",in=cookie"`
+}
+
+func TestSwaggerObjectConstructorProcessHandler_withHeaderParameter(t *testing.T) {
+	p := NewPlugin(nil)
+
+	rd := &ucon.RouteDefinition{
+		Method:       "GET",
+		PathTemplate: ucon.ParsePathTemplate("/api/test/{id}"),
+		HandlerContainer: &handlerContainerImpl{
+			handler: func(c context.Context, req *ReqSwaggerHeaderParameter) (*Resp, error) {
+				return nil, nil
+			},
+		},
+	}
+
+	err := p.constructor.processHandler(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := p.constructor.object.Paths["/api/test/{id}"].Get
+	if v := len(op.Parameters); v != 2 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := op.Parameters[0]; v.Name != "X-Request-Id" || v.In != "header" || v.Type != "string" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := op.Parameters[1]; v.Name != "id" || v.In != "path" {
+		t.Errorf("unexpected: %#v", v)
+	}
+}
+
+type ReqSwaggerFileParameter struct {
+	ID          int64        `json:"id"`
+	Text        string       `json:"text" swagger:",req"`
+	Attachment  *ucon.File   `json:"attachment"`
+	Attachments []*ucon.File `json:"attachments"`
+}
+
+func TestSwaggerObjectConstructorProcessHandler_withFileParameter(t *testing.T) {
+	p := NewPlugin(nil)
+
+	rd := &ucon.RouteDefinition{
+		Method:       "POST",
+		PathTemplate: ucon.ParsePathTemplate("/api/test/{id}"),
+		HandlerContainer: &handlerContainerImpl{
+			handler: func(c context.Context, req *ReqSwaggerFileParameter) (*Resp, error) {
+				return nil, nil
+			},
+		},
+	}
+
+	err := p.constructor.processHandler(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := p.constructor.object.Paths["/api/test/{id}"].Post
+	if v := strings.Join(op.Consumes, ","); v != "multipart/form-data" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := len(op.Parameters); v != 4 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := op.Parameters[0]; v.Name != "id" || v.In != "path" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := op.Parameters[1]; v.Name != "attachment" || v.In != "formData" || v.Type != "file" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := op.Parameters[2]; v.Name != "attachments" || v.In != "formData" || v.Type != "file" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := op.Parameters[3]; v.Name != "text" || v.In != "formData" || v.Type != "string" || !v.Required {
+		t.Errorf("unexpected: %#v", v)
+	}
+}
+
+func TestSwaggerObjectConstructorProcessHandler_withStatus(t *testing.T) {
+	p := NewPlugin(nil)
+
+	rds := []*ucon.RouteDefinition{
+		{
+			Method:       "POST",
+			PathTemplate: ucon.ParsePathTemplate("/api/test"),
+			HandlerContainer: &handlerContainerImpl{
+				handler: func(c context.Context, req *ReqSwaggerParameter) (*Resp, error) {
+					return nil, nil
+				},
+			},
+			Status: http.StatusCreated,
+		},
+		{
+			Method:       "DELETE",
+			PathTemplate: ucon.ParsePathTemplate("/api/test"),
+			HandlerContainer: &handlerContainerImpl{
+				handler: func(c context.Context, req *ReqSwaggerParameter) (ucon.StatusCode, error) {
+					return http.StatusNoContent, nil
+				},
+			},
+			Status: http.StatusNoContent,
+		},
+		{
+			Method:       "PUT",
+			PathTemplate: ucon.ParsePathTemplate("/api/test"),
+			HandlerContainer: &handlerContainerImpl{
+				handler: func(c context.Context, req *ReqSwaggerParameter) (*ucon.Response, error) {
+					return nil, nil
+				},
+			},
+		},
+	}
+	for _, rd := range rds {
+		err := p.constructor.processHandler(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := p.constructor.execFinisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := p.constructor.object.Paths["/api/test"]
+	if v := item.Post.Responses["201"]; v == nil || v.Schema == nil {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := item.Post.Responses["200"]; v != nil {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := item.Delete.Responses["204"]; v == nil || v.Schema != nil {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := item.Put.Responses["200"]; v == nil || v.Schema != nil {
+		t.Errorf("unexpected: %#v", v)
+	}
+}
+
+func TestSwaggerObjectConstructorProcessHandler_withStream(t *testing.T) {
+	p := NewPlugin(nil)
+
+	rds := []*ucon.RouteDefinition{
+		{
+			Method:       "GET",
+			PathTemplate: ucon.ParsePathTemplate("/api/items"),
+			HandlerContainer: &handlerContainerImpl{
+				handler: func(c context.Context) (<-chan *Resp, error) {
+					return nil, nil
+				},
+			},
+		},
+		{
+			Method:       "GET",
+			PathTemplate: ucon.ParsePathTemplate("/api/events"),
+			HandlerContainer: &handlerContainerImpl{
+				handler: func(c context.Context, lastEventID ucon.LastEventID) (ucon.EventStream, error) {
+					return nil, nil
+				},
+			},
+		},
+		{
+			Method:       "GET",
+			PathTemplate: ucon.ParsePathTemplate("/api/export"),
+			HandlerContainer: &handlerContainerImpl{
+				handler: func(c context.Context) (io.ReadCloser, error) {
+					return nil, nil
+				},
+			},
+		},
+	}
+	for _, rd := range rds {
+		err := p.constructor.processHandler(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := p.constructor.execFinisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := p.constructor.object.Paths["/api/items"].Get.Responses["200"].Schema; v.Type != "array" || v.Items == nil || v.Items.Ref != "#/definitions/Resp" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := p.constructor.object.Paths["/api/export"].Get.Responses["200"].Schema; v.Type != "file" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	op := p.constructor.object.Paths["/api/events"].Get
+	if v := op.Responses["200"].Schema; v.Type != "string" {
+		t.Errorf("unexpected: %#v", v)
+	}
+	if v := strings.Join(op.Produces, ","); v != "text/event-stream" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := len(op.Parameters); v != 0 {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestSwaggerObjectConstructorProcessHandler_withWebSocket(t *testing.T) {
+	p := NewPlugin(nil)
+
+	rd := &ucon.RouteDefinition{
+		Method:       "GET",
+		PathTemplate: ucon.ParsePathTemplate("/api/ws/{id}"),
+		HandlerContainer: &handlerContainerImpl{
+			handler: func(c context.Context, conn ucon.WSConn, req *ReqSwaggerParameter) error {
+				return nil
+			},
+		},
+	}
+	err := p.constructor.processHandler(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.constructor.execFinisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := p.constructor.object.Paths["/api/ws/{id}"].Get
+	if v := len(op.Parameters); v != 4 {
+		t.Errorf("unexpected: %v", v)
+	}
+}
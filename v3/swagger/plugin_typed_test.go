@@ -0,0 +1,40 @@
+//go:build go1.18
+// +build go1.18
+
+package swagger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/favclip/ucon/v3"
+)
+
+func TestSwaggerObjectConstructorProcessHandler_withTypedHandler(t *testing.T) {
+	p := NewPlugin(&Options{
+		Object: &Object{
+			Info: &Info{
+				Title:   "test",
+				Version: "v1",
+			},
+		},
+	})
+
+	mux := ucon.NewServeMux()
+	mux.Plugin(p)
+	ucon.HandleTyped(mux, "GET", "/api/test/{id}", func(c context.Context, req *ReqSwaggerParameter) (*Resp, error) {
+		return nil, nil
+	})
+	mux.Prepare()
+
+	item := p.constructor.object.Paths["/api/test/{id}"]
+	if item == nil || item.Get == nil {
+		t.Fatalf("unexpected: %v", item)
+	}
+	if v := len(item.Get.Parameters); v != 4 {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := item.Get.Responses["200"].Schema.Ref; v != "#/definitions/Resp" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
@@ -1,8 +1,15 @@
 package ucon
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -16,6 +23,10 @@ type BubbleTestOption struct {
 	ContentType       string
 	Body              io.Reader
 	MiddlewareContext Context
+	// ProvidedValues are registered by ServeMux.ProvideValue, e.g. mocks of handler arguments.
+	ProvidedValues []interface{}
+	// Providers are registered by ServeMux.Provide with RequestScope.
+	Providers []interface{}
 }
 
 // MakeMiddlewareTestBed returns a Bubble and ServeMux for handling the request made from the option.
@@ -31,6 +42,12 @@ func MakeMiddlewareTestBed(t *testing.T, middleware MiddlewareFunc, handler inte
 	}
 	mux := NewServeMux()
 	mux.Middleware(middleware)
+	for _, v := range opts.ProvidedValues {
+		mux.ProvideValue(v)
+	}
+	for _, p := range opts.Providers {
+		mux.Provide(RequestScope, p)
+	}
 
 	r, err := http.NewRequest(opts.Method, opts.URL, opts.Body)
 	if err != nil {
@@ -98,3 +115,82 @@ func MakeHandlerTestBed(t *testing.T, method string, path string, body io.Reader
 
 	return resp
 }
+
+// MakeWebSocketTestBed connects to the WebSocket endpoint at the path, and returns the client side of the connection.
+// To test some handlers, those must be registered by Handle or HandleFunc before calling this.
+// If the handshake is rejected, it returns nil and the response.
+func MakeWebSocketTestBed(t *testing.T, path string, header http.Header) (WSConn, *http.Response) {
+	ts := httptest.NewServer(DefaultMux)
+	// the connection is alive after closing, because it is hijacked from the server.
+	defer ts.Close()
+
+	reqURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqURL, err = reqURL.Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, resp, err := dialWebSocket(reqURL.String(), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, resp
+}
+
+// dialWebSocket makes the handshake with the server of the URL, only plain HTTP is supported.
+func dialWebSocket(rawURL string, header http.Header) (WSConn, *http.Response, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	var nonce [16]byte
+	_, err = rand.Read(nonce[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	conn, err := net.Dial("tcp", req.URL.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, err := ioutil.ReadAll(resp.Body)
+		conn.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return nil, resp, nil
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		conn.Close()
+		return nil, nil, errors.New("websocket: invalid Sec-WebSocket-Accept")
+	}
+
+	return newWSConn(conn, br, false), resp, nil
+}
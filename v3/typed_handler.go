@@ -0,0 +1,65 @@
+//go:build go1.18
+// +build go1.18
+
+package ucon
+
+import (
+	"context"
+	"reflect"
+)
+
+// HandlerRegistrar is a destination of route registration, ServeMux or RouteGroup.
+type HandlerRegistrar interface {
+	Handle(method string, path string, hc HandlerContainer, opts ...RouteOption)
+}
+
+// HandleTyped registers the typed handler for the given method & path.
+// The request object is made by RequestObjectMapper and the response is written by ResponseMapper as same as HandleFunc,
+// but the handler is called without reflection.
+// The handler is also exposed by HandlerContainer.Handler as an ordinary function, so plugins like swagger can inspect it.
+func HandleTyped[Req any, Resp any](reg HandlerRegistrar, method string, path string, h func(c context.Context, req *Req) (*Resp, error), opts ...RouteOption) {
+	reg.Handle(method, path, &typedHandlerContainer[Req, Resp]{
+		handler: h,
+		Context: background,
+	}, opts...)
+}
+
+type typedHandlerContainer[Req any, Resp any] struct {
+	handler func(c context.Context, req *Req) (*Resp, error)
+	Context
+}
+
+func (hc *typedHandlerContainer[Req, Resp]) Handler() interface{} {
+	return hc.handler
+}
+
+func (hc *typedHandlerContainer[Req, Resp]) invoke(b *Bubble) error {
+	if len(b.Arguments) != 2 {
+		return ErrInvalidArgumentLength
+	}
+
+	c, ok := argumentValue[context.Context](b.Arguments[0])
+	if !ok {
+		return ErrInvalidArgumentValue
+	}
+	req, ok := argumentValue[*Req](b.Arguments[1])
+	if !ok {
+		return ErrInvalidArgumentValue
+	}
+
+	resp, err := hc.handler(c, req)
+
+	b.Returns = []reflect.Value{reflect.ValueOf(resp), reflect.ValueOf(&err).Elem()}
+
+	return nil
+}
+
+// argumentValue returns the value injected into Bubble.Arguments by middlewares.
+func argumentValue[T any](v reflect.Value) (T, bool) {
+	var zero T
+	if !v.IsValid() {
+		return zero, false
+	}
+	t, ok := v.Interface().(T)
+	return t, ok
+}
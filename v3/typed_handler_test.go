@@ -0,0 +1,101 @@
+//go:build go1.18
+// +build go1.18
+
+package ucon
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandleTyped(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleTyped(DefaultMux, "PUT", "/api/test/{id}", func(c context.Context, req *RequestOfRoutingInfoAddHandlers) (*ResponseOfRoutingInfoAddHandlers, error) {
+		if v := req.ID; v != 1 {
+			t.Errorf("unexpected: %v", v)
+		}
+		if v := req.Offset; v != 100 {
+			t.Errorf("unexpected: %v", v)
+		}
+		return &ResponseOfRoutingInfoAddHandlers{Text: req.Text + "!"}, nil
+	})
+	HandleTyped(Group("/admin"), "GET", "/error", func(c context.Context, req *RequestOfRoutingInfoAddHandlers) (*ResponseOfRoutingInfoAddHandlers, error) {
+		return nil, &httpError{Code: http.StatusConflict, Message: "conflict"}
+	}, WithName("error"))
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "PUT", "/api/test/1?offset=100", strings.NewReader(`{"text":"Hi!"}`))
+	if v := resp.StatusCode; v != http.StatusOK {
+		t.Errorf("unexpected: %v", v)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(body); v != `{"text":"Hi!!"}` {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	resp = MakeHandlerTestBed(t, "GET", "/admin/error", nil)
+	if v := resp.StatusCode; v != http.StatusConflict {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	// the handler is exposed as an ordinary function for plugins
+	hT := reflect.TypeOf(DefaultMux.router.handlers[0].HandlerContainer.Handler())
+	if v := hT.NumIn(); v != 2 {
+		t.Fatalf("unexpected: %v", v)
+	}
+	if v := hT.In(1); v != reflect.TypeOf(&RequestOfRoutingInfoAddHandlers{}) {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := hT.Out(0); v != reflect.TypeOf(&ResponseOfRoutingInfoAddHandlers{}) {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestHandleTyped_withoutRequestObjectMapper(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Middleware(ContextDI())
+
+	HandleTyped(DefaultMux, "GET", "/api/test", func(c context.Context, req *RequestOfRoutingInfoAddHandlers) (*ResponseOfRoutingInfoAddHandlers, error) {
+		return nil, errors.New("unreachable")
+	})
+
+	DefaultMux.Prepare()
+
+	resp := MakeHandlerTestBed(t, "GET", "/api/test", nil)
+	if v := resp.StatusCode; v != http.StatusInternalServerError {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func BenchmarkServeMuxServeHTTP_typed(b *testing.B) {
+	mux := NewServeMux()
+	mux.Middleware(ResponseMapper())
+	mux.Middleware(HTTPRWDI())
+	mux.Middleware(ContextDI())
+	mux.Middleware(RequestObjectMapper())
+
+	HandleTyped(mux, "GET", "/api/todo/{id}", func(c context.Context, req *requestOfHandlerPlan) (*ResponseOfRoutingInfoAddHandlers, error) {
+		return &ResponseOfRoutingInfoAddHandlers{Text: req.Text}, nil
+	})
+	mux.Prepare()
+
+	r := httptest.NewRequest("GET", "/api/todo/1?offset=100&text=hi&tags=a&tags=b", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
@@ -0,0 +1,467 @@
+package ucon
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types of WebSocket, the opcodes of RFC 6455.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close codes of WebSocket. see RFC 6455, section 7.4.1.
+const (
+	CloseNormalClosure       = 1000
+	CloseGoingAway           = 1001
+	CloseProtocolError       = 1002
+	CloseUnsupportedData     = 1003
+	CloseNoStatusReceived    = 1005
+	CloseInvalidPayloadData  = 1007
+	ClosePolicyViolation     = 1008
+	CloseMessageTooBig       = 1009
+	CloseInternalServerError = 1011
+)
+
+// DefaultWSReadLimit is the default max bytes of a message read by WSConn.
+const DefaultWSReadLimit = 1 << 20 // 1 MB
+
+const wsContinuationFrame = 0
+
+// wsGUID is the magic string to make Sec-WebSocket-Accept. see RFC 6455, section 1.3.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrWSBadHandshake is the error that the request is not a valid WebSocket handshake.
+var ErrWSBadHandshake = newBadRequestf("websocket: bad handshake")
+
+// ErrWSClosed is the error that the connection is already closed.
+var ErrWSClosed = errors.New("websocket: connection closed")
+
+// WSConn is a WebSocket connection given to handlers as an argument.
+// The request is upgraded after the middleware chain, just before the handler is called,
+// so the handler can take WSConn with other arguments, e.g. `func(c context.Context, req *Params, conn ucon.WSConn) error`.
+// The connection is closed when the handler returns, with CloseInternalServerError if the handler returns an error.
+// The close reason is the error object of the response body, and the message of an unexpected error is hidden unless Debug.
+type WSConn interface {
+	// ReadMessage returns the next text or binary message.
+	// Ping frames are answered automatically, and a close frame is returned as *WSCloseError.
+	ReadMessage() (messageType int, data []byte, err error)
+	// WriteMessage writes a message. It can be called concurrently with ReadMessage.
+	WriteMessage(messageType int, data []byte) error
+	// ReadJSON reads the next message and decodes it as JSON.
+	ReadJSON(v interface{}) error
+	// WriteJSON encodes v as JSON and writes it as a text message.
+	WriteJSON(v interface{}) error
+	// Ping writes a ping frame.
+	Ping(data []byte) error
+	// Close writes a close frame and closes the connection. The reason is truncated to 123 bytes, the limit of the frame.
+	Close(code int, reason string) error
+	// SetReadLimit sets the max bytes of a message, DefaultWSReadLimit is used by default.
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// WSCloseError is the error returned by WSConn.ReadMessage when the peer closed the connection.
+type WSCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WSCloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Reason)
+}
+
+var wsConnType = reflect.TypeOf((*WSConn)(nil)).Elem()
+
+type wsConn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isServer bool
+
+	readLimit int64
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func newWSConn(conn net.Conn, br *bufio.Reader, isServer bool) *wsConn {
+	return &wsConn{
+		conn:      conn,
+		br:        br,
+		isServer:  isServer,
+		readLimit: DefaultWSReadLimit,
+	}
+}
+
+// upgradeWebSocket upgrades the request if the handler takes WSConn.
+// It returns nil if the handler does not take it.
+func (b *Bubble) upgradeWebSocket() (*wsConn, error) {
+	slots := b.handlerPlan().wsConnSlots
+	if len(slots) == 0 {
+		return nil, nil
+	}
+
+	r := b.R
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Key") == "" {
+		return nil, ErrWSBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		b.W.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, ErrWSBadHandshake
+	}
+	hj, ok := b.W.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("websocket: response does not implement http.Hijacker")
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	b.hijacked = true
+
+	// headers set by middlewares, e.g. Set-Cookie, are sent with the handshake response.
+	header := b.W.Header().Clone()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+	_, err = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	if err == nil {
+		err = header.Write(brw)
+	}
+	if err == nil {
+		_, err = brw.WriteString("\r\n")
+	}
+	if err == nil {
+		err = brw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ws := newWSConn(conn, brw.Reader, true)
+	for _, idx := range slots {
+		b.Arguments[idx] = reflect.ValueOf(ws)
+	}
+
+	return ws, nil
+}
+
+// finish closes the connection by the result of the handler.
+// The error is sent as the reason in the same form as the response body of errors,
+// and messages of errors other than HTTPErrorResponse are hidden unless ServeMux.Debug is true.
+func (ws *wsConn) finish(b *Bubble, err error) {
+	for _, rv := range b.Returns {
+		if rv.Type().AssignableTo(errorType) && !rv.IsNil() {
+			err = rv.Interface().(error)
+		}
+	}
+	if err == nil {
+		ws.Close(CloseNormalClosure, "")
+		return
+	}
+
+	he, ok := err.(HTTPErrorResponse)
+	if !ok {
+		he = newHTTPError(http.StatusInternalServerError)
+		if b.Debug {
+			he = &httpError{Code: http.StatusInternalServerError, Message: err.Error()}
+		}
+	}
+	msgObj := he.ErrorMessage()
+	if msgObj == nil {
+		msgObj = he
+	}
+	reason, mErr := json.Marshal(msgObj)
+	if mErr != nil {
+		reason = nil
+	}
+	ws.Close(CloseInternalServerError, string(reason))
+}
+
+// truncateUTF8 truncates s to n bytes at most, not to split a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContainsToken(h http.Header, name string, token string) bool {
+	for _, value := range h[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ws *wsConn) ReadMessage() (int, []byte, error) {
+	messageType := 0
+	var message []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			err := ws.writeFrame(PongMessage, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			closeErr := &WSCloseError{Code: CloseNoStatusReceived}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+				// echo the close frame
+				ws.Close(closeErr.Code, "")
+			} else {
+				// CloseNoStatusReceived must not be sent, echo the empty close frame.
+				ws.closeWith(nil)
+			}
+			return 0, nil, closeErr
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "unexpected data frame")
+			}
+			messageType = opcode
+		case wsContinuationFrame:
+			if messageType == 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, ws.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(message)+len(payload)) > ws.readLimit {
+			return 0, nil, ws.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, ws.fail(CloseInvalidPayloadData, "invalid UTF-8")
+		}
+		return messageType, message, nil
+	}
+}
+
+func (ws *wsConn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	_, err = io.ReadFull(ws.br, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, ws.fail(CloseProtocolError, "reserved bits are set")
+	}
+	opcode = int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	if masked != ws.isServer {
+		// clients must mask frames, and servers must not.
+		return false, 0, nil, ws.fail(CloseProtocolError, "invalid mask")
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(ws.br, ext[:])
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(ws.br, ext[:])
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if opcode >= CloseMessage && (length > 125 || !fin) {
+		return false, 0, nil, ws.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length < 0 || length > ws.readLimit {
+		return false, 0, nil, ws.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(ws.br, mask[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	_, err = io.ReadFull(ws.br, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// fail closes the connection by the protocol error, and returns the error.
+func (ws *wsConn) fail(code int, reason string) error {
+	ws.Close(code, reason)
+	return &WSCloseError{Code: code, Reason: reason}
+}
+
+func (ws *wsConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	return ws.writeFrame(messageType, data)
+}
+
+func (ws *wsConn) writeFrame(opcode int, payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	return ws.writeFrameLocked(opcode, payload)
+}
+
+func (ws *wsConn) writeFrameLocked(opcode int, payload []byte) error {
+	if ws.closed {
+		return ErrWSClosed
+	}
+
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|byte(opcode))
+
+	maskBit := byte(0)
+	if !ws.isServer {
+		maskBit = 0x80
+	}
+	length := len(payload)
+	switch {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[len(frame)-2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(length))
+	}
+
+	if ws.isServer {
+		frame = append(frame, payload...)
+	} else {
+		var mask [4]byte
+		_, err := rand.Read(mask[:])
+		if err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		for i, c := range payload {
+			frame = append(frame, c^mask[i%4])
+		}
+	}
+
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+func (ws *wsConn) ReadJSON(v interface{}) error {
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (ws *wsConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(TextMessage, data)
+}
+
+func (ws *wsConn) Ping(data []byte) error {
+	return ws.writeFrame(PingMessage, data)
+}
+
+func (ws *wsConn) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, truncateUTF8(reason, 123)...)
+
+	return ws.closeWith(payload)
+}
+
+func (ws *wsConn) closeWith(payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	if ws.closed {
+		return nil
+	}
+
+	ws.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	ws.writeFrameLocked(CloseMessage, payload)
+	ws.closed = true
+
+	return ws.conn.Close()
+}
+
+func (ws *wsConn) SetReadLimit(limit int64) {
+	ws.readLimit = limit
+}
+
+func (ws *wsConn) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+func (ws *wsConn) SetWriteDeadline(t time.Time) error {
+	return ws.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,345 @@
+package ucon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ParamsOfWebSocket struct {
+	InPath
+	Room string `json:"room"`
+}
+
+// handlerOfWebSocketEcho echoes messages with the room, and sends the error which stops reading to closed.
+func handlerOfWebSocketEcho(closed chan<- error) func(c context.Context, req *ParamsOfWebSocket, conn WSConn) error {
+	return func(c context.Context, req *ParamsOfWebSocket, conn WSConn) error {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				closed <- err
+				return nil
+			}
+			err = conn.WriteMessage(messageType, append([]byte(req.Room+": "), data...))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+	Middleware(func(b *Bubble) error {
+		b.W.Header().Set("X-Middleware", "called")
+		return b.Next()
+	})
+
+	closed := make(chan error, 1)
+	HandleFunc("GET", "/api/echo/{room}", handlerOfWebSocketEcho(closed))
+
+	DefaultMux.Prepare()
+
+	conn, resp := MakeWebSocketTestBed(t, "/api/echo/lobby", nil)
+	if v := resp.Header.Get("X-Middleware"); v != "called" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	err := conn.WriteMessage(TextMessage, []byte("Hi!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != TextMessage {
+		t.Errorf("unexpected: %v", messageType)
+	}
+	if v := string(data); v != "lobby: Hi!" {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	// larger than 125 bytes uses the extended payload length
+	err = conn.WriteMessage(BinaryMessage, []byte(strings.Repeat("a", 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageType, data, err = conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != BinaryMessage {
+		t.Errorf("unexpected: %v", messageType)
+	}
+	if v := len(data); v != len("lobby: ")+1000 {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	err = conn.Close(CloseNormalClosure, "bye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-closed
+	if v, ok := err.(*WSCloseError); !ok || v.Code != CloseNormalClosure || v.Reason != "bye" {
+		t.Errorf("unexpected: %#v", err)
+	}
+}
+
+func TestWebSocket_withPing(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("GET", "/api/echo/{room}", handlerOfWebSocketEcho(make(chan error, 1)))
+
+	DefaultMux.Prepare()
+
+	c, _ := MakeWebSocketTestBed(t, "/api/echo/lobby", nil)
+	conn := c.(*wsConn)
+	defer conn.Close(CloseNormalClosure, "")
+
+	err := conn.Ping([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.WriteMessage(TextMessage, []byte("Hi!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, opcode, payload, err := conn.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != PongMessage {
+		t.Errorf("unexpected: %v", opcode)
+	}
+	if v := string(payload); v != "ping" {
+		t.Errorf("unexpected: %v", v)
+	}
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(data); v != "lobby: Hi!" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestWebSocket_withProtocolErrors(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	closed := make(chan error, 1)
+	HandleFunc("GET", "/api/echo/{room}", handlerOfWebSocketEcho(closed))
+	HandleFunc("GET", "/api/limit", func(conn WSConn) error {
+		conn.SetReadLimit(8)
+		_, _, err := conn.ReadMessage()
+		closed <- err
+		return nil
+	})
+
+	DefaultMux.Prepare()
+
+	conn, _ := MakeWebSocketTestBed(t, "/api/echo/lobby", nil)
+	err := conn.(*wsConn).writeFrame(TextMessage, []byte{0xff, 0xfe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	if v, ok := err.(*WSCloseError); !ok || v.Code != CloseInvalidPayloadData {
+		t.Errorf("unexpected: %#v", err)
+	}
+	<-closed
+
+	conn, _ = MakeWebSocketTestBed(t, "/api/limit", nil)
+	err = conn.WriteMessage(TextMessage, []byte("too long message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	if v, ok := err.(*WSCloseError); !ok || v.Code != CloseMessageTooBig {
+		t.Errorf("unexpected: %#v", err)
+	}
+	err = <-closed
+	if v, ok := err.(*WSCloseError); !ok || v.Code != CloseMessageTooBig {
+		t.Errorf("unexpected: %#v", err)
+	}
+}
+
+func TestWebSocket_withHandlerError(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("GET", "/api/error", func(conn WSConn) error {
+		return errors.New("boom")
+	})
+	HandleFunc("GET", "/api/forbidden", func(conn WSConn) error {
+		return newHTTPError(http.StatusForbidden)
+	})
+
+	DefaultMux.Prepare()
+
+	for _, debug := range []bool{false, true} {
+		DefaultMux.Debug = debug
+		expected := `{"code":500,"message":"Internal Server Error"}`
+		if debug {
+			expected = `{"code":500,"message":"boom"}`
+		}
+
+		conn, _ := MakeWebSocketTestBed(t, "/api/error", nil)
+		_, _, err := conn.ReadMessage()
+		if v, ok := err.(*WSCloseError); !ok || v.Code != CloseInternalServerError || v.Reason != expected {
+			t.Errorf("unexpected: %#v", err)
+		}
+	}
+
+	conn, _ := MakeWebSocketTestBed(t, "/api/forbidden", nil)
+	_, _, err := conn.ReadMessage()
+	if v, ok := err.(*WSCloseError); !ok || v.Code != CloseInternalServerError || v.Reason != `{"code":403,"message":"Forbidden"}` {
+		t.Errorf("unexpected: %#v", err)
+	}
+}
+
+func TestWSConn_withEmptyClose(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := newWSConn(c1, bufio.NewReader(c1), false)
+	server := newWSConn(c2, bufio.NewReader(c2), true)
+
+	go func() {
+		// the masked close frame without the status
+		c1.Write([]byte{0x88, 0x80, 0, 0, 0, 0})
+	}()
+	echoed := make(chan []byte, 1)
+	go func() {
+		_, _, payload, _ := client.readFrame()
+		echoed <- payload
+	}()
+
+	_, _, err := server.ReadMessage()
+	if v, ok := err.(*WSCloseError); !ok || v.Code != CloseNoStatusReceived {
+		t.Errorf("unexpected: %#v", err)
+	}
+	// CloseNoStatusReceived is not sent
+	if v := <-echoed; len(v) != 0 {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	if v := truncateUTF8("こんにちは", 7); v != "こん" {
+		t.Errorf("unexpected: %v", v)
+	}
+	if v := truncateUTF8("hello", 7); v != "hello" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestWebSocket_withBadHandshake(t *testing.T) {
+	DefaultMux = NewServeMux()
+	Orthodox()
+
+	HandleFunc("GET", "/api/echo/{room}", handlerOfWebSocketEcho(nil))
+
+	DefaultMux.Prepare()
+
+	r := httptest.NewRequest("GET", "/api/echo/lobby", nil)
+	w := httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/api/echo/lobby", nil)
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Sec-WebSocket-Version", "8")
+	w = httptest.NewRecorder()
+	DefaultMux.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected: %v", w.Code)
+	}
+	if v := w.Header().Get("Sec-WebSocket-Version"); v != "13" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestWSConn_withFragments(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := newWSConn(c1, bufio.NewReader(c1), false)
+	server := newWSConn(c2, bufio.NewReader(c2), true)
+	defer client.Close(CloseNormalClosure, "")
+
+	go func() {
+		// masked frames by the client, "Hel" + ping + "lo"
+		c1.Write([]byte{0x01, 0x83, 0, 0, 0, 0, 'H', 'e', 'l'})
+		c1.Write([]byte{0x89, 0x80, 0, 0, 0, 0})
+		c1.Write([]byte{0x80, 0x82, 1, 1, 1, 1, 'l' ^ 1, 'o' ^ 1})
+	}()
+	go func() {
+		// pong from the server
+		client.readFrame()
+	}()
+
+	messageType, data, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != TextMessage {
+		t.Errorf("unexpected: %v", messageType)
+	}
+	if v := string(data); v != "Hello" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestWSAcceptKey(t *testing.T) {
+	// the example of RFC 6455
+	if v := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); v != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected: %v", v)
+	}
+}
+
+func TestMakeWebSocketTestBed(t *testing.T) {
+	DefaultMux = NewServeMux()
+
+	HandleFunc("GET", "/api/ws", func(conn WSConn) error {
+		return conn.WriteJSON(map[string]string{"text": "Hi!"})
+	})
+	DefaultMux.Prepare()
+
+	conn, resp := MakeWebSocketTestBed(t, "/api/ws", nil)
+	if v := resp.StatusCode; v != http.StatusSwitchingProtocols {
+		t.Errorf("unexpected: %v", v)
+	}
+
+	var v map[string]string
+	err := conn.ReadJSON(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["text"] != "Hi!" {
+		t.Errorf("unexpected: %v", v)
+	}
+	_, _, err = conn.ReadMessage()
+	if v, ok := err.(*WSCloseError); !ok || v.Code != CloseNormalClosure {
+		t.Errorf("unexpected: %#v", err)
+	}
+
+	conn, resp = MakeWebSocketTestBed(t, "/api/unknown", nil)
+	if conn != nil {
+		t.Errorf("unexpected: %v", conn)
+	}
+	if v := resp.StatusCode; v != http.StatusNotFound {
+		t.Errorf("unexpected: %v", v)
+	}
+}